@@ -0,0 +1,131 @@
+package item
+
+// Meta holds the metadata memcached returns for the meta protocol
+// commands. Fields the server didn't report are left as zero values.
+type Meta struct {
+	// CasID is the compare and swap ID of the item.
+	CasID uint64
+	// TTL is the number of seconds until the item expires, or -1 if it
+	// has no expiration time.
+	TTL int32
+	// LastAccess is the number of seconds since the item was last accessed.
+	LastAccess int32
+	// Hit reports whether the item had been fetched before.
+	Hit bool
+	// Size is the size of the item value in bytes.
+	Size int
+	// Opaque is the opaque token sent with the request, echoed back.
+	Opaque string
+	// Won reports whether this client won the right to recache the item.
+	Won bool
+	// Stale reports whether the item is stale.
+	Stale bool
+	// AlreadyWon reports whether another client already won the right to
+	// recache the item.
+	AlreadyWon bool
+}
+
+// MetaGetOptions modifies the behavior of a meta get.
+type MetaGetOptions struct {
+	// Base64Key sends the key base64 encoded, which allows binary keys.
+	Base64Key bool
+	// Quiet suppresses the response on a miss. It is only useful when
+	// pipelining requests.
+	Quiet bool
+	// NoLRUBump avoids bumping the item in the LRU.
+	NoLRUBump bool
+	// Opaque is a token of up to 32 bytes echoed back in Meta.Opaque.
+	Opaque string
+	// Touch updates the expiration time of the item to TouchTTL.
+	Touch    bool
+	TouchTTL int32
+	// VivifyTTL, if not zero, creates a placeholder item with this TTL on a
+	// miss. The client that creates it wins the right to recache the item.
+	VivifyTTL int32
+	// RecacheTTL, if not zero, makes this client win the right to recache
+	// the item if its remaining TTL is lower than RecacheTTL.
+	RecacheTTL int32
+}
+
+// MetaSetMode selects how a meta set stores the item.
+type MetaSetMode byte
+
+// Meta set modes.
+const (
+	MetaSetModeSet     MetaSetMode = 'S'
+	MetaSetModeAdd     MetaSetMode = 'E'
+	MetaSetModeAppend  MetaSetMode = 'A'
+	MetaSetModePrepend MetaSetMode = 'P'
+	MetaSetModeReplace MetaSetMode = 'R'
+)
+
+// MetaSetOptions modifies the behavior of a meta set.
+type MetaSetOptions struct {
+	// Base64Key sends the key base64 encoded, which allows binary keys.
+	Base64Key bool
+	// Quiet suppresses the response on success. It is only useful when
+	// pipelining requests.
+	Quiet bool
+	// Opaque is a token of up to 32 bytes echoed back in Meta.Opaque.
+	Opaque string
+	// Mode selects how the item is stored. It defaults to MetaSetModeSet.
+	Mode MetaSetMode
+	// CompareCasID, if not zero, only stores the item if its CAS ID
+	// matches.
+	CompareCasID uint64
+	// Invalidate marks the item as stale instead of failing when
+	// CompareCasID is older than the item CAS ID.
+	Invalidate bool
+}
+
+// MetaDeleteOptions modifies the behavior of a meta delete.
+type MetaDeleteOptions struct {
+	// Base64Key sends the key base64 encoded, which allows binary keys.
+	Base64Key bool
+	// Quiet suppresses the response on success. It is only useful when
+	// pipelining requests.
+	Quiet bool
+	// Opaque is a token of up to 32 bytes echoed back in Meta.Opaque.
+	Opaque string
+	// CompareCasID, if not zero, only deletes the item if its CAS ID
+	// matches.
+	CompareCasID uint64
+	// Invalidate marks the item as stale instead of deleting it, so the
+	// next meta get wins the right to recache it.
+	Invalidate bool
+	// InvalidateTTL updates the TTL of an invalidated item.
+	InvalidateTTL int32
+}
+
+// MetaArithmeticMode selects the operation of a meta arithmetic.
+type MetaArithmeticMode byte
+
+// Meta arithmetic modes.
+const (
+	MetaArithmeticIncrement MetaArithmeticMode = 'I'
+	MetaArithmeticDecrement MetaArithmeticMode = 'D'
+)
+
+// MetaArithmeticOptions modifies the behavior of a meta arithmetic.
+type MetaArithmeticOptions struct {
+	// Base64Key sends the key base64 encoded, which allows binary keys.
+	Base64Key bool
+	// Quiet suppresses the response on success. It is only useful when
+	// pipelining requests.
+	Quiet bool
+	// Opaque is a token of up to 32 bytes echoed back in Meta.Opaque.
+	Opaque string
+	// Mode selects the operation. It defaults to MetaArithmeticIncrement.
+	Mode MetaArithmeticMode
+	// Delta is the amount to increment or decrement by. It defaults to 1,
+	// so MetaArithmetic doesn't change a value by zero; Increment and
+	// Decrement use their delta as it is.
+	Delta uint64
+	// CompareCasID, if not zero, only updates the value if its CAS ID
+	// matches.
+	CompareCasID uint64
+	// VivifyTTL, if not zero, creates the item with InitialValue and this
+	// TTL on a miss.
+	VivifyTTL    int32
+	InitialValue uint64
+}
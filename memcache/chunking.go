@@ -0,0 +1,331 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// flagChunked marks an item holding the manifest of a chunked value.
+const flagChunked uint32 = 1 << 30
+
+// chunkManifestVersion starts the manifests of chunked values.
+const chunkManifestVersion = "chunks1"
+
+// maxChunks is the largest number of chunks of a value, which bounds the
+// values read back from manifests found in the servers.
+const maxChunks = 1024
+
+// ErrChunkIntegrity is returned when a chunk of a chunked value is
+// missing or doesn't belong to the value anymore.
+var ErrChunkIntegrity = errors.New("memcache: chunked value is incomplete or corrupt")
+
+// ErrChunkedValueTooLarge is returned when a value needs more chunks than
+// a chunked value can have.
+var ErrChunkedValueTooLarge = errors.New("memcache: value is too large to be chunked")
+
+// chunkManifest describes the chunks of a value.
+type chunkManifest struct {
+	// token identifies the write of the value, so that the chunks of
+	// previous writes aren't mixed with the current ones.
+	token string
+	count int
+	size  int
+	sum   [sha256.Size]byte
+}
+
+// key returns the key of the chunk i of the value at key. The key of the
+// value is hashed, so that the chunk keys of any valid key are valid too.
+func (m *chunkManifest) key(key string, i int) string {
+	sum := sha256.Sum256([]byte(key))
+	return "chunk:" + hex.EncodeToString(sum[:]) + ":" + m.token + ":" + strconv.Itoa(i)
+}
+
+// keys returns the keys of the chunks of the value at key.
+func (m *chunkManifest) keys(key string) []string {
+	keys := make([]string, m.count)
+	for i := range keys {
+		keys[i] = m.key(key, i)
+	}
+	return keys
+}
+
+func (m *chunkManifest) encode() []byte {
+	return []byte(fmt.Sprintf("%s %s %d %d %s", chunkManifestVersion, m.token, m.count, m.size, hex.EncodeToString(m.sum[:])))
+}
+
+// parseChunkManifest parses the manifest of a value stored in chunks of at
+// most chunkSize bytes. Manifests whose number of chunks doesn't match the
+// size of the value, or exceeds maxChunks, are rejected, so that a corrupt
+// manifest can't make the value read back unbounded.
+func parseChunkManifest(value []byte, chunkSize int) (*chunkManifest, error) {
+	var version, sum string
+	m := new(chunkManifest)
+	if _, err := fmt.Sscanf(string(value), "%s %s %d %d %s", &version, &m.token, &m.count, &m.size, &sum); err != nil {
+		return nil, ErrChunkIntegrity
+	}
+	if version != chunkManifestVersion || m.count < 0 || m.size < 0 || hex.DecodedLen(len(sum)) != sha256.Size {
+		return nil, ErrChunkIntegrity
+	}
+	if m.count > maxChunks || m.count != chunkCount(m.size, chunkSize) {
+		return nil, ErrChunkIntegrity
+	}
+	if _, err := hex.Decode(m.sum[:], []byte(sum)); err != nil {
+		return nil, ErrChunkIntegrity
+	}
+	return m, nil
+}
+
+// chunkCount returns the number of chunks of at most chunkSize bytes of a
+// value of size bytes.
+func chunkCount(size, chunkSize int) int {
+	return (size + chunkSize - 1) / chunkSize
+}
+
+// chunker stores the values longer than size in chunks of at most size
+// bytes, with the client.
+type chunker struct {
+	client Client
+	size   int
+}
+
+// newChunkingInterceptor returns an interceptor storing the values longer
+// than size bytes in chunks, with client, and a manifest at their key. The
+// chunks are read back with a single GetMulti.
+//
+// Set, Replace and CompareAndSwap read the manifest of the value they
+// overwrite, to delete its chunks once the write succeeds. The chunks of a
+// value overwritten concurrently by another client may still be left in
+// the servers until they expire or are evicted.
+func newChunkingInterceptor(client Client, size int) Interceptor {
+	c := &chunker{client: client, size: size}
+	return c.intercept
+}
+
+func (c *chunker) intercept(ctx context.Context, call *Call, invoker Invoker) error {
+	if rawValues(ctx) {
+		return invoker(ctx, call)
+	}
+	switch call.Operation {
+	case "Set", "Add", "Replace", "CompareAndSwap":
+		if call.Item == nil {
+			return invoker(ctx, call)
+		}
+		var previous *item.Item
+		if call.Operation != "Add" {
+			previous, _ = c.client.GetContext(ctx, call.Item.Key)
+		}
+		var chunks []string
+		if len(call.Item.Value) > c.size {
+			manifest, err := c.store(ctx, call.Item)
+			if err != nil {
+				return err
+			}
+			call.Item = manifest
+			chunks = c.chunkKeys(manifest)
+		}
+		if err := invoker(ctx, call); err != nil {
+			// The chunks of a value which wasn't stored can't be read.
+			c.deleteChunks(ctx, chunks)
+			return err
+		}
+		c.deleteChunks(ctx, c.chunkKeys(previous))
+		return nil
+
+	case "Get", "MetaGet":
+		err := invoker(ctx, call)
+		if err != nil || call.Item == nil || call.Item.Flags&flagChunked == 0 {
+			return err
+		}
+		items, failed := c.load(ctx, []*item.Item{call.Item})
+		if err := failed[call.Item.Key]; err != nil {
+			return err
+		}
+		call.Item = items[call.Item.Key]
+		return nil
+
+	case "GetMulti":
+		err := invoker(ctx, call)
+		var manifests []*item.Item
+		for _, it := range call.Items {
+			if it.Flags&flagChunked != 0 {
+				manifests = append(manifests, it)
+			}
+		}
+		if len(manifests) == 0 {
+			return err
+		}
+		loaded, failed := c.load(ctx, manifests)
+		items := make(map[string]*item.Item, len(call.Items))
+		for key, it := range call.Items {
+			items[key] = it
+		}
+		for key, it := range loaded {
+			items[key] = it
+		}
+		for key, ferr := range failed {
+			// The other items are given back along with the error.
+			delete(items, key)
+			if err == nil {
+				err = ferr
+			}
+		}
+		call.Items = items
+		return err
+
+	case "Touch":
+		if err := invoker(ctx, call); err != nil {
+			return err
+		}
+		manifest, err := c.client.GetContext(ctx, call.Keys[0])
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, key := range c.chunkKeys(manifest) {
+			if err := c.client.TouchContext(ctx, key, call.Seconds); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "Delete":
+		manifest, _ := c.client.GetContext(ctx, call.Keys[0])
+		if err := invoker(ctx, call); err != nil {
+			return err
+		}
+		// The value is deleted with its manifest, deleting the chunks
+		// only frees their memory earlier.
+		c.deleteChunks(ctx, c.chunkKeys(manifest))
+		return nil
+	}
+	return invoker(ctx, call)
+}
+
+// store stores the chunks of the value of it and returns the item holding
+// their manifest.
+func (c *chunker) store(ctx context.Context, it *item.Item) (*item.Item, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	m := &chunkManifest{
+		token: hex.EncodeToString(token),
+		count: chunkCount(len(it.Value), c.size),
+		size:  len(it.Value),
+		sum:   sha256.Sum256(it.Value),
+	}
+	if m.count > maxChunks {
+		return nil, ErrChunkedValueTooLarge
+	}
+	for i := 0; i < m.count; i++ {
+		end := (i + 1) * c.size
+		if end > len(it.Value) {
+			end = len(it.Value)
+		}
+		err := c.client.SetContext(ctx, &item.Item{
+			Key:        m.key(it.Key, i),
+			Value:      it.Value[i*c.size : end],
+			Expiration: it.Expiration,
+		})
+		if err != nil {
+			c.deleteChunks(ctx, m.keys(it.Key)[:i])
+			return nil, err
+		}
+	}
+
+	manifest := *it
+	manifest.Value = m.encode()
+	manifest.Flags |= flagChunked
+	return &manifest, nil
+}
+
+// load reads the chunks of the manifests and returns the items with their
+// values, and the errors of the items whose chunks are missing or corrupt,
+// by key.
+func (c *chunker) load(ctx context.Context, manifests []*item.Item) (map[string]*item.Item, map[string]error) {
+	items := make(map[string]*item.Item, len(manifests))
+	failed := make(map[string]error)
+
+	parsed := make(map[string]*chunkManifest, len(manifests))
+	var keys []string
+	for _, it := range manifests {
+		m, err := parseChunkManifest(it.Value, c.size)
+		if err != nil {
+			failed[it.Key] = err
+			continue
+		}
+		parsed[it.Key] = m
+		keys = append(keys, m.keys(it.Key)...)
+	}
+
+	chunks, err := c.client.GetMultiContext(ctx, keys)
+	for _, it := range manifests {
+		m, ok := parsed[it.Key]
+		if !ok {
+			continue
+		}
+		value, ok := assembleChunks(it.Key, m, chunks)
+		if !ok {
+			failed[it.Key] = ErrChunkIntegrity
+			if err != nil {
+				failed[it.Key] = err
+			}
+			continue
+		}
+		cp := *it
+		cp.Value = value
+		cp.Flags &^= flagChunked
+		items[it.Key] = &cp
+	}
+	return items, failed
+}
+
+// assembleChunks returns the value of the chunks of the value at key,
+// and whether they were all found and match the manifest.
+func assembleChunks(key string, m *chunkManifest, chunks map[string]*item.Item) ([]byte, bool) {
+	value := make([]byte, 0, m.size)
+	for i := 0; i < m.count; i++ {
+		chunk, ok := chunks[m.key(key, i)]
+		if !ok {
+			return nil, false
+		}
+		value = append(value, chunk.Value...)
+	}
+	sum := sha256.Sum256(value)
+	if len(value) != m.size || !bytes.Equal(sum[:], m.sum[:]) {
+		return nil, false
+	}
+	return value, true
+}
+
+// chunkKeys returns the keys of the chunks of the value whose manifest is
+// it, if it is one.
+func (c *chunker) chunkKeys(it *item.Item) []string {
+	if it == nil || it.Flags&flagChunked == 0 {
+		return nil
+	}
+	m, err := parseChunkManifest(it.Value, c.size)
+	if err != nil {
+		return nil
+	}
+	return m.keys(it.Key)
+}
+
+// deleteChunks deletes the chunks at keys. Chunks left behind only take
+// memory until they expire or are evicted, so the errors are ignored.
+func (c *chunker) deleteChunks(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		c.client.DeleteContext(ctx, key)
+	}
+}
@@ -0,0 +1,267 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestChunking(t *testing.T) {
+	value := make([]byte, 1050)
+	for i := range value {
+		value[i] = byte(i)
+	}
+
+	newChunkingClient := func() (Client, *memcachemock.FakeClient) {
+		fake := memcachemock.NewFakeClient()
+		return NewBuilder().WithClient(fake).WithChunking(100).Build(), fake
+	}
+	storedChunkKeys := func(t *testing.T, fake *memcachemock.FakeClient, key string, size int) []string {
+		manifest, err := fake.Get(key)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return (&chunker{size: size}).chunkKeys(manifest)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		if err := client.Set(&item.Item{Key: "foo", Value: value, Flags: CodecJSON}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if keys := storedChunkKeys(t, fake, "foo", 100); len(keys) != 11 {
+			t.Errorf("Expected %v chunks, got %v", 11, len(keys))
+		}
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) {
+			t.Errorf("Expected value to be reassembled")
+		}
+		if it.Flags != CodecJSON {
+			t.Errorf("Expected flags to be %#x, got %#x", CodecJSON, it.Flags)
+		}
+	})
+
+	t.Run("SmallValue", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+		if keys := storedChunkKeys(t, fake, "foo", 100); keys != nil {
+			t.Errorf("Expected small value not to be chunked, got %v chunks", len(keys))
+		}
+	})
+
+	t.Run("MissingChunk", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		fake.Delete(storedChunkKeys(t, fake, "foo", 100)[5])
+
+		if _, err := client.Get("foo"); !errors.Is(err, ErrChunkIntegrity) {
+			t.Errorf("Expected error to be %v, got %v", ErrChunkIntegrity, err)
+		}
+	})
+
+	t.Run("StaleChunk", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		fake.Set(&item.Item{Key: storedChunkKeys(t, fake, "foo", 100)[0], Value: bytes.Repeat([]byte("x"), 100)})
+
+		if _, err := client.Get("foo"); !errors.Is(err, ErrChunkIntegrity) {
+			t.Errorf("Expected error to be %v, got %v", ErrChunkIntegrity, err)
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		client.Set(&item.Item{Key: "bar", Value: []byte("bar")})
+		client.Set(&item.Item{Key: "broken", Value: value})
+		fake.Delete(storedChunkKeys(t, fake, "broken", 100)[0])
+
+		items, err := client.GetMulti([]string{"foo", "bar", "broken"})
+		if !errors.Is(err, ErrChunkIntegrity) {
+			t.Errorf("Expected error to be %v, got %v", ErrChunkIntegrity, err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("Expected %v items, got %v", 2, len(items))
+		}
+		if !bytes.Equal(items["foo"].Value, value) {
+			t.Errorf("Expected value to be reassembled")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		keys := storedChunkKeys(t, fake, "foo", 100)
+
+		if err := client.Delete("foo"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := fake.Get(keys[0]); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		first := storedChunkKeys(t, fake, "foo", 100)
+
+		if err := client.Set(&item.Item{Key: "foo", Value: value}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := fake.Get(first[0]); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if n := fake.Len(); n != 1 {
+			t.Errorf("Expected %v item to be stored, got %v", 1, n)
+		}
+	})
+
+	t.Run("NotStored", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		if err := client.Add(&item.Item{Key: "foo", Value: value}); !errors.Is(err, memcache.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrNotStored, err)
+		}
+		if n := fake.Len(); n != 1 {
+			t.Errorf("Expected %v item to be stored, got %v", 1, n)
+		}
+	})
+
+	t.Run("CorruptManifest", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		manifest, _ := fake.Get("foo")
+		m, err := parseChunkManifest(manifest.Value, 100)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		for name, corrupt := range map[string]*chunkManifest{
+			"TooManyChunks": {token: m.token, count: 12, size: m.size, sum: m.sum},
+			"TooFewChunks":  {token: m.token, count: 10, size: m.size, sum: m.sum},
+			"TooLarge":      {token: m.token, count: maxChunks + 1, size: (maxChunks + 1) * 100, sum: m.sum},
+		} {
+			manifest.Value = corrupt.encode()
+			fake.Set(manifest)
+			if _, err := client.Get("foo"); !errors.Is(err, ErrChunkIntegrity) {
+				t.Errorf("%v: Expected error to be %v, got %v", name, ErrChunkIntegrity, err)
+			}
+		}
+	})
+
+	t.Run("ValueTooLarge", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		err := client.Set(&item.Item{Key: "foo", Value: make([]byte, maxChunks*100+1)})
+		if !errors.Is(err, ErrChunkedValueTooLarge) {
+			t.Errorf("Expected error to be %v, got %v", ErrChunkedValueTooLarge, err)
+		}
+		if n := fake.Len(); n != 0 {
+			t.Errorf("Expected %v items to be stored, got %v", 0, n)
+		}
+	})
+
+	t.Run("GetMultiKeepsItems", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		client.Set(&item.Item{Key: "foo", Value: value})
+		manifest, _ := fake.Get("foo")
+
+		found := map[string]*item.Item{"foo": manifest}
+		c := &chunker{client: client, size: 100}
+		call := &Call{Operation: "GetMulti", Keys: []string{"foo"}}
+		err := c.intercept(context.Background(), call, func(ctx context.Context, call *Call) error {
+			call.Items = found
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(call.Items["foo"].Value, value) {
+			t.Errorf("Expected value to be reassembled")
+		}
+		if found["foo"] != manifest {
+			t.Errorf("Expected the items found not to be modified")
+		}
+	})
+
+	t.Run("LongKey", func(t *testing.T) {
+		client, _ := newChunkingClient()
+		key := strings.Repeat("k", 240)
+		if err := client.Set(&item.Item{Key: key, Value: value}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := client.Get(key)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) {
+			t.Errorf("Expected value to be reassembled")
+		}
+	})
+
+	t.Run("GetOrLoad", func(t *testing.T) {
+		client, fake := newChunkingClient()
+		got, err := client.GetOrLoad(context.Background(), "foo", time.Minute, func() ([]byte, error) {
+			return value, nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Errorf("Expected loaded value to be returned")
+		}
+		if keys := storedChunkKeys(t, fake, "foo", 100); len(keys) != 11 {
+			t.Errorf("Expected %v chunks, got %v", 11, len(keys))
+		}
+
+		got, err = client.GetOrLoad(context.Background(), "foo", time.Minute, func() ([]byte, error) {
+			return nil, errors.New("unexpected load")
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Errorf("Expected cached value to be reassembled")
+		}
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := NewBuilder().
+			WithClient(fake).
+			WithCompression(CompressionGzip, 0).
+			WithChunking(16).
+			WithKeyPrefix("ns:").
+			Build()
+
+		compressible := bytes.Repeat([]byte("chunk"), 1000)
+		if err := client.Set(&item.Item{Key: "foo", Value: compressible}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if keys := storedChunkKeys(t, fake, "ns:foo", 16); len(keys) < 2 || len(keys) > 10 {
+			t.Errorf("Expected the compressed value to be chunked, got %v chunks", len(keys))
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "foo" || !bytes.Equal(it.Value, compressible) {
+			t.Errorf("Expected value to be reassembled and decompressed")
+		}
+	})
+}
@@ -2,10 +2,13 @@
 package memcache
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/getmiranda/gomemcached/item"
+	"golang.org/x/sync/singleflight"
 )
 
 type Client interface {
@@ -64,29 +67,95 @@ type Client interface {
 	Decrement(key string, delta uint64) (newValue uint64, err error)
 	// Exists returns true if an item with the given key exists.
 	Exists(key string) (bool, error)
+
+	// FlushAllContext is like FlushAll but honors the deadline and
+	// cancellation of ctx.
+	FlushAllContext(ctx context.Context) error
+	// GetContext is like Get but honors the deadline and cancellation of ctx.
+	GetContext(ctx context.Context, key string) (*item.Item, error)
+	// TouchContext is like Touch but honors the deadline and cancellation
+	// of ctx.
+	TouchContext(ctx context.Context, key string, seconds int32) (err error)
+	// GetMultiContext is like GetMulti but honors the deadline and
+	// cancellation of ctx.
+	GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error)
+	// SetContext is like Set but honors the deadline and cancellation of ctx.
+	SetContext(ctx context.Context, item *item.Item) error
+	// AddContext is like Add but honors the deadline and cancellation of ctx.
+	AddContext(ctx context.Context, item *item.Item) error
+	// ReplaceContext is like Replace but honors the deadline and
+	// cancellation of ctx.
+	ReplaceContext(ctx context.Context, item *item.Item) error
+	// CompareAndSwapContext is like CompareAndSwap but honors the deadline
+	// and cancellation of ctx.
+	CompareAndSwapContext(ctx context.Context, item *item.Item) error
+	// DeleteContext is like Delete but honors the deadline and
+	// cancellation of ctx.
+	DeleteContext(ctx context.Context, key string) error
+	// DeleteAllContext is like DeleteAll but honors the deadline and
+	// cancellation of ctx.
+	DeleteAllContext(ctx context.Context) error
+	// PingContext is like Ping but honors the deadline and cancellation
+	// of ctx.
+	PingContext(ctx context.Context) error
+	// IncrementContext is like Increment but honors the deadline and
+	// cancellation of ctx.
+	IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error)
+	// DecrementContext is like Decrement but honors the deadline and
+	// cancellation of ctx.
+	DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error)
+	// ExistsContext is like Exists but honors the deadline and cancellation
+	// of ctx.
+	ExistsContext(ctx context.Context, key string) (bool, error)
+
+	// GetOrLoad gets the value for the given key. On a cache miss the value
+	// is obtained from loader and stored with the given ttl. Concurrent
+	// misses for the same key are collapsed into a single loader call.
+	// If loader returns ErrNotFound and a negative TTL was configured,
+	// the miss is cached for that long.
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error)
+
+	// MetaGet gets the item for the given key along with its metadata,
+	// using the meta protocol. ErrCacheMiss is returned for a memcache
+	// cache miss. ErrMetaProtocolRequired is returned if the client was
+	// not built with UseMetaProtocol.
+	MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error)
+	// MetaSet writes the given item using the meta protocol. The item's
+	// CasID is updated with the one assigned by the server.
+	// ErrMetaProtocolRequired is returned if the client was not built
+	// with UseMetaProtocol.
+	MetaSet(ctx context.Context, item *item.Item, opts *item.MetaSetOptions) (*item.Meta, error)
+	// MetaDelete deletes or invalidates the item with the provided key
+	// using the meta protocol. ErrMetaProtocolRequired is returned if the
+	// client was not built with UseMetaProtocol.
+	MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error)
+	// MetaArithmetic atomically increments or decrements key using the
+	// meta protocol and returns the new value. ErrMetaProtocolRequired is
+	// returned if the client was not built with UseMetaProtocol.
+	MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error)
+	// MetaNoop checks all instances answer a meta no-op. Returns error if
+	// any of them is down. ErrMetaProtocolRequired is returned if the
+	// client was not built with UseMetaProtocol.
+	MetaNoop(ctx context.Context) error
 }
 
 type client struct {
-	mcClient *memcache.Client
+	transport   transport
+	negativeTTL time.Duration
+	loadGroup   singleflight.Group
 }
 
 /* Implementations */
 
 // FlushAll deletes all items in the cache.
 func (c *client) FlushAll() error {
-	return c.mcClient.FlushAll()
+	return c.FlushAllContext(context.Background())
 }
 
 // Get gets the item for the given key. ErrCacheMiss is returned for a
 // memcache cache miss. The key must be at most 250 bytes in length.
 func (c *client) Get(key string) (*item.Item, error) {
-	it, err := c.mcClient.Get(key)
-	if err != nil {
-		return nil, err
-	}
-	alias := (*item.Item)(it)
-	return alias, nil
-
+	return c.GetContext(context.Background(), key)
 }
 
 // Touch updates the expiry for the given key. The seconds parameter is either
@@ -95,7 +164,7 @@ func (c *client) Get(key string) (*item.Item, error) {
 // no expiration time. ErrCacheMiss is returned if the key is not in the cache.
 // The key must be at most 250 bytes in length.
 func (c *client) Touch(key string, seconds int32) (err error) {
-	return c.mcClient.Touch(key, seconds)
+	return c.TouchContext(context.Background(), key, seconds)
 }
 
 // GetMulti is a batch version of Get. The returned map from keys to
@@ -103,35 +172,24 @@ func (c *client) Touch(key string, seconds int32) (err error) {
 // cache misses. Each key must be at most 250 bytes in length.
 // If no error is returned, the returned map will also be non-nil.
 func (c *client) GetMulti(keys []string) (map[string]*item.Item, error) {
-	multi, err := c.mcClient.GetMulti(keys)
-	if err != nil {
-		return nil, err
-	}
-	items := make(map[string]*item.Item)
-	for k, v := range multi {
-		items[k] = (*item.Item)(v)
-	}
-	return items, nil
+	return c.GetMultiContext(context.Background(), keys)
 }
 
 // Set writes the given item, unconditionally.
 func (c *client) Set(item *item.Item) error {
-	alias := (*memcache.Item)(item)
-	return c.mcClient.Set(alias)
+	return c.SetContext(context.Background(), item)
 }
 
 // Add writes the given item, if no value already exists for its
 // key. ErrNotStored is returned if that condition is not met.
 func (c *client) Add(item *item.Item) error {
-	alias := (*memcache.Item)(item)
-	return c.mcClient.Add(alias)
+	return c.AddContext(context.Background(), item)
 }
 
 // Replace writes the given item, but only if the server *does*
 // already hold data for this key.
 func (c *client) Replace(item *item.Item) error {
-	alias := (*memcache.Item)(item)
-	return c.mcClient.Replace(alias)
+	return c.ReplaceContext(context.Background(), item)
 }
 
 // CompareAndSwap writes the given item that was previously returned
@@ -142,25 +200,24 @@ func (c *client) Replace(item *item.Item) error {
 // calls. ErrNotStored is returned if the value was evicted in between
 // the calls.
 func (c *client) CompareAndSwap(item *item.Item) error {
-	alias := (*memcache.Item)(item)
-	return c.mcClient.CompareAndSwap(alias)
+	return c.CompareAndSwapContext(context.Background(), item)
 }
 
 // Delete deletes the item with the provided key. The error ErrCacheMiss is
 // returned if the item didn't already exist in the cache.
 func (c *client) Delete(key string) error {
-	return c.mcClient.Delete(key)
+	return c.DeleteContext(context.Background(), key)
 }
 
 // DeleteAll deletes all items in the cache.
 func (c *client) DeleteAll() error {
-	return c.mcClient.DeleteAll()
+	return c.DeleteAllContext(context.Background())
 }
 
 // Ping checks all instances if they are alive. Returns error if any
 // of them is down.
 func (c *client) Ping() error {
-	return c.mcClient.Ping()
+	return c.PingContext(context.Background())
 }
 
 // Increment atomically increments key by delta. The return value is
@@ -169,7 +226,7 @@ func (c *client) Ping() error {
 // memcached must be an decimal number, or an error will be returned.
 // On 64-bit overflow, the new value wraps around.
 func (c *client) Increment(key string, delta uint64) (newValue uint64, err error) {
-	return c.mcClient.Increment(key, delta)
+	return c.IncrementContext(context.Background(), key, delta)
 }
 
 // Decrement atomically decrements key by delta. The return value is
@@ -179,12 +236,93 @@ func (c *client) Increment(key string, delta uint64) (newValue uint64, err error
 // On underflow, the new value is capped at zero and does not wrap
 // around.
 func (c *client) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	return c.mcClient.Decrement(key, delta)
+	return c.DecrementContext(context.Background(), key, delta)
 }
 
 // Exists returns true if an item with the given key exists.
 func (c *client) Exists(key string) (bool, error) {
-	it, err := c.mcClient.Get(key)
+	return c.ExistsContext(context.Background(), key)
+}
+
+// FlushAllContext is like FlushAll but honors the deadline and
+// cancellation of ctx.
+func (c *client) FlushAllContext(ctx context.Context) error {
+	return c.transport.FlushAll(ctx)
+}
+
+// GetContext is like Get but honors the deadline and cancellation of ctx.
+func (c *client) GetContext(ctx context.Context, key string) (*item.Item, error) {
+	return c.transport.Get(ctx, key)
+}
+
+// TouchContext is like Touch but honors the deadline and cancellation
+// of ctx.
+func (c *client) TouchContext(ctx context.Context, key string, seconds int32) (err error) {
+	return c.transport.Touch(ctx, key, seconds)
+}
+
+// GetMultiContext is like GetMulti but honors the deadline and
+// cancellation of ctx.
+func (c *client) GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	return c.transport.GetMulti(ctx, keys)
+}
+
+// SetContext is like Set but honors the deadline and cancellation of ctx.
+func (c *client) SetContext(ctx context.Context, item *item.Item) error {
+	return c.transport.Set(ctx, item)
+}
+
+// AddContext is like Add but honors the deadline and cancellation of ctx.
+func (c *client) AddContext(ctx context.Context, item *item.Item) error {
+	return c.transport.Add(ctx, item)
+}
+
+// ReplaceContext is like Replace but honors the deadline and
+// cancellation of ctx.
+func (c *client) ReplaceContext(ctx context.Context, item *item.Item) error {
+	return c.transport.Replace(ctx, item)
+}
+
+// CompareAndSwapContext is like CompareAndSwap but honors the deadline
+// and cancellation of ctx.
+func (c *client) CompareAndSwapContext(ctx context.Context, item *item.Item) error {
+	return c.transport.CompareAndSwap(ctx, item)
+}
+
+// DeleteContext is like Delete but honors the deadline and
+// cancellation of ctx.
+func (c *client) DeleteContext(ctx context.Context, key string) error {
+	return c.transport.Delete(ctx, key)
+}
+
+// DeleteAllContext is like DeleteAll but honors the deadline and
+// cancellation of ctx.
+func (c *client) DeleteAllContext(ctx context.Context) error {
+	return c.transport.DeleteAll(ctx)
+}
+
+// PingContext is like Ping but honors the deadline and cancellation
+// of ctx.
+func (c *client) PingContext(ctx context.Context) error {
+	return c.transport.Ping(ctx)
+}
+
+// IncrementContext is like Increment but honors the deadline and
+// cancellation of ctx.
+func (c *client) IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	return c.transport.Increment(ctx, key, delta)
+}
+
+// DecrementContext is like Decrement but honors the deadline and
+// cancellation of ctx.
+func (c *client) DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	return c.transport.Decrement(ctx, key, delta)
+}
+
+// ExistsContext is like Exists but honors the deadline and cancellation
+// of ctx.
+func (c *client) ExistsContext(ctx context.Context, key string) (bool, error) {
+	it, err := c.GetContext(ctx, key)
 	if err != nil {
 		if errors.Is(err, memcache.ErrCacheMiss) {
 			return false, nil
@@ -193,3 +331,40 @@ func (c *client) Exists(key string) (bool, error) {
 	}
 	return it != nil, nil
 }
+
+// MetaGet gets the item for the given key along with its metadata,
+// using the meta protocol. ErrCacheMiss is returned for a memcache
+// cache miss. ErrMetaProtocolRequired is returned if the client was
+// not built with UseMetaProtocol.
+func (c *client) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	return c.transport.MetaGet(ctx, key, opts)
+}
+
+// MetaSet writes the given item using the meta protocol. The item's
+// CasID is updated with the one assigned by the server.
+// ErrMetaProtocolRequired is returned if the client was not built
+// with UseMetaProtocol.
+func (c *client) MetaSet(ctx context.Context, item *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	return c.transport.MetaSet(ctx, item, opts)
+}
+
+// MetaDelete deletes or invalidates the item with the provided key
+// using the meta protocol. ErrMetaProtocolRequired is returned if the
+// client was not built with UseMetaProtocol.
+func (c *client) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	return c.transport.MetaDelete(ctx, key, opts)
+}
+
+// MetaArithmetic atomically increments or decrements key using the
+// meta protocol and returns the new value. ErrMetaProtocolRequired is
+// returned if the client was not built with UseMetaProtocol.
+func (c *client) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error) {
+	return c.transport.MetaArithmetic(ctx, key, opts)
+}
+
+// MetaNoop checks all instances answer a meta no-op. Returns error if
+// any of them is down. ErrMetaProtocolRequired is returned if the
+// client was not built with UseMetaProtocol.
+func (c *client) MetaNoop(ctx context.Context) error {
+	return c.transport.MetaNoop(ctx)
+}
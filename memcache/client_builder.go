@@ -1,15 +1,22 @@
 package memcache
 
 import (
+	"crypto/tls"
+	"log/slog"
+	"net"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/getmiranda/gomemcached/memcachemock"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	DefaultTimeout      = time.Duration(time.Second * 3)
 	DefaultMaxIdleConns = 100
+	// DefaultChunkSize leaves room for the key and the overhead of the
+	// items under the 1MB item size limit of memcached.
+	DefaultChunkSize = 1000 * 1024
 )
 
 // ClientBuilder is the interface for building a client.
@@ -28,6 +35,88 @@ type ClientBuilder interface {
 	// with equal weight. If a server is listed multiple times,
 	// it gets a proportional amount of weight.
 	WithServers(servers ...string) ClientBuilder
+	// UseKetama configures the client to distribute keys over the servers
+	// with a ketama consistent hashing ring, compatible with libmemcached.
+	// Adding or removing a server only remaps the keys that belonged to it.
+	UseKetama() ClientBuilder
+	// WithServerWeights sets the weight of each server on the ketama ring.
+	// Servers without an entry get weight 1. Without UseKetama, the
+	// operations of the client fail with ErrServerWeights.
+	WithServerWeights(weights map[string]int) ClientBuilder
+	// SetNegativeTTL specifies for how long GetOrLoad caches that a loader
+	// returned ErrNotFound. If zero, negative results are not cached.
+	SetNegativeTTL(ttl time.Duration) ClientBuilder
+	// UseMetaProtocol configures the client to talk to the servers with
+	// memcached's meta commands instead of the classic text protocol.
+	// It is required by the Meta operations and needs memcached 1.6 or
+	// later.
+	UseMetaProtocol() ClientBuilder
+	// WithClient makes Build return the given client instead of creating
+	// one, such as the client of a memcachemock.NewMockServer or a
+	// memcachemock.FakeClient. It takes precedence over the global
+	// memcachemock.MockupServer. The client of a mock server gets the
+	// timeout given with SetTimeout.
+	WithClient(client Client) ClientBuilder
+	// WithInterceptors adds interceptors running around every operation
+	// of the built client, including the ones of a client given with
+	// WithClient or of the mock server. The first interceptor is the
+	// outermost.
+	WithInterceptors(interceptors ...Interceptor) ClientBuilder
+	// WithTracing records an OpenTelemetry span for every operation, with
+	// the tracer provider, or the global one if nil. Spans are children of
+	// the span of the context given to the Context operations.
+	WithTracing(tp trace.TracerProvider) ClientBuilder
+	// WithMetrics records the metrics of the operations and of the
+	// connection pool of the client into metrics, which can be shared by
+	// several clients.
+	WithMetrics(metrics *Metrics) ClientBuilder
+	// WithLogger logs the failed operations with the logger, along with
+	// their keys, server and error type. Cache misses aren't failures.
+	WithLogger(logger *slog.Logger) ClientBuilder
+	// SetSlowThreshold makes the logger of WithLogger log the operations
+	// lasting longer than threshold at warn level. If zero, slow
+	// operations aren't logged.
+	SetSlowThreshold(threshold time.Duration) ClientBuilder
+	// WithKeyRedactor makes the logger of WithLogger write the keys as
+	// returned by redact, such as a hash of the keys holding user
+	// identifiers. redact is given the keys stored in the servers, after
+	// their prefix and normalization. The text of the errors, which may
+	// hold keys, is then left out and only their type is logged.
+	WithKeyRedactor(redact KeyRedactor) ClientBuilder
+	// WithKeyPrefix stores the keys with prefix, so that services sharing
+	// the servers don't collide on keys. The items found are given back
+	// without the prefix.
+	WithKeyPrefix(prefix string) ClientBuilder
+	// WithKeyNormalizer stores the keys, including their prefix, as
+	// returned by normalize, such as HashInvalidKeys. The items found are
+	// given back with the keys of the caller.
+	WithKeyNormalizer(normalize KeyNormalizer) ClientBuilder
+	// WithCompression compresses with compression the values of the items
+	// stored longer than threshold bytes, and decompresses the values of
+	// the items found. Values written by clients without compression are
+	// given back as they are. Values larger than the client can store once
+	// decompressed fail with ErrDecompressedTooLarge.
+	WithCompression(compression Compression, threshold int) ClientBuilder
+	// WithChunking stores the values longer than chunkSize bytes, after
+	// their compression, in chunks of at most chunkSize bytes along with a
+	// manifest at their key. If less than one, DefaultChunkSize is used.
+	// Get fails with ErrChunkIntegrity rather than returning a truncated
+	// value if a chunk is missing or doesn't match the manifest, and Set
+	// fails with ErrChunkedValueTooLarge if the value needs more than 1024
+	// chunks. Values chunked with another chunk size can't be read back.
+	// MetaSet stores values as they are, without chunking them.
+	WithChunking(chunkSize int) ClientBuilder
+	// WithEncryption encrypts the values of the items stored with
+	// encryption, after their compression, bound to the key and flags
+	// stored in the servers. The items found that can't be decrypted fail with
+	// ErrDecryption, including the ones that aren't encrypted.
+	WithEncryption(encryption *Encryption) ClientBuilder
+	// WithTLSConfig wraps the connections to the servers in TLS with
+	// config, for memcached 1.6 or later built with TLS support. Without
+	// ServerName in config, the servers are verified with their host name
+	// if they all share it, or else with their IP address. Without
+	// ClientSessionCache, the client keeps the TLS sessions to resume them.
+	WithTLSConfig(config *tls.Config) ClientBuilder
 	// Build builds the memcache client.
 	Build() Client
 }
@@ -36,6 +125,26 @@ type clientBuilder struct {
 	timeout      time.Duration
 	maxIdleConns int
 	servers      []string
+	ketama       bool
+	weights      map[string]int
+	negativeTTL  time.Duration
+	metaProtocol bool
+	client       Client
+	interceptors []Interceptor
+	tracing      bool
+	tp           trace.TracerProvider
+	metrics      *Metrics
+	logger       *slog.Logger
+	slow         time.Duration
+	redact       KeyRedactor
+	keyPrefix    string
+	normalize    KeyNormalizer
+	compression  Compression
+	threshold    int
+	chunking     bool
+	chunkSize    int
+	encryption   *Encryption
+	tlsConfig    *tls.Config
 }
 
 // SetTimeout specifies the socket read/write timeout.
@@ -64,17 +173,277 @@ func (c *clientBuilder) WithServers(servers ...string) ClientBuilder {
 	return c
 }
 
+// UseKetama configures the client to distribute keys over the servers
+// with a ketama consistent hashing ring, compatible with libmemcached.
+// Adding or removing a server only remaps the keys that belonged to it.
+func (c *clientBuilder) UseKetama() ClientBuilder {
+	c.ketama = true
+	return c
+}
+
+// WithServerWeights sets the weight of each server on the ketama ring.
+// Servers without an entry get weight 1. Without UseKetama, the
+// operations of the client fail with ErrServerWeights.
+func (c *clientBuilder) WithServerWeights(weights map[string]int) ClientBuilder {
+	c.weights = weights
+	return c
+}
+
+// SetNegativeTTL specifies for how long GetOrLoad caches that a loader
+// returned ErrNotFound. If zero, negative results are not cached.
+func (c *clientBuilder) SetNegativeTTL(ttl time.Duration) ClientBuilder {
+	c.negativeTTL = ttl
+	return c
+}
+
+// UseMetaProtocol configures the client to talk to the servers with
+// memcached's meta commands instead of the classic text protocol.
+// It is required by the Meta operations and needs memcached 1.6 or
+// later.
+func (c *clientBuilder) UseMetaProtocol() ClientBuilder {
+	c.metaProtocol = true
+	return c
+}
+
+// WithClient makes Build return the given client instead of creating
+// one, such as the client of a memcachemock.NewMockServer or a
+// memcachemock.FakeClient. It takes precedence over the global
+// memcachemock.MockupServer. The client of a mock server gets the
+// timeout given with SetTimeout.
+func (c *clientBuilder) WithClient(client Client) ClientBuilder {
+	c.client = client
+	return c
+}
+
+// WithInterceptors adds interceptors running around every operation
+// of the built client, including the ones of a client given with
+// WithClient or of the mock server. The first interceptor is the
+// outermost.
+func (c *clientBuilder) WithInterceptors(interceptors ...Interceptor) ClientBuilder {
+	c.interceptors = append(c.interceptors, interceptors...)
+	return c
+}
+
+// WithTracing records an OpenTelemetry span for every operation, with
+// the tracer provider, or the global one if nil. Spans are children of
+// the span of the context given to the Context operations.
+func (c *clientBuilder) WithTracing(tp trace.TracerProvider) ClientBuilder {
+	c.tracing = true
+	c.tp = tp
+	return c
+}
+
+// WithMetrics records the metrics of the operations and of the
+// connection pool of the client into metrics, which can be shared by
+// several clients.
+func (c *clientBuilder) WithMetrics(metrics *Metrics) ClientBuilder {
+	c.metrics = metrics
+	return c
+}
+
+// WithLogger logs the failed operations with the logger, along with
+// their keys, server and error type. Cache misses aren't failures.
+func (c *clientBuilder) WithLogger(logger *slog.Logger) ClientBuilder {
+	c.logger = logger
+	return c
+}
+
+// SetSlowThreshold makes the logger of WithLogger log the operations
+// lasting longer than threshold at warn level. If zero, slow
+// operations aren't logged.
+func (c *clientBuilder) SetSlowThreshold(threshold time.Duration) ClientBuilder {
+	c.slow = threshold
+	return c
+}
+
+// WithKeyRedactor makes the logger of WithLogger write the keys as
+// returned by redact, such as a hash of the keys holding user
+// identifiers. redact is given the keys stored in the servers, after
+// their prefix and normalization. The text of the errors, which may
+// hold keys, is then left out and only their type is logged.
+func (c *clientBuilder) WithKeyRedactor(redact KeyRedactor) ClientBuilder {
+	c.redact = redact
+	return c
+}
+
+// WithKeyPrefix stores the keys with prefix, so that services sharing
+// the servers don't collide on keys. The items found are given back
+// without the prefix.
+func (c *clientBuilder) WithKeyPrefix(prefix string) ClientBuilder {
+	c.keyPrefix = prefix
+	return c
+}
+
+// WithKeyNormalizer stores the keys, including their prefix, as
+// returned by normalize, such as HashInvalidKeys. The items found are
+// given back with the keys of the caller.
+func (c *clientBuilder) WithKeyNormalizer(normalize KeyNormalizer) ClientBuilder {
+	c.normalize = normalize
+	return c
+}
+
+// WithCompression compresses with compression the values of the items
+// stored longer than threshold bytes, and decompresses the values of
+// the items found. Values written by clients without compression are
+// given back as they are. Values larger than the client can store once
+// decompressed fail with ErrDecompressedTooLarge.
+func (c *clientBuilder) WithCompression(compression Compression, threshold int) ClientBuilder {
+	c.compression = compression
+	c.threshold = threshold
+	return c
+}
+
+// WithChunking stores the values longer than chunkSize bytes, after
+// their compression, in chunks of at most chunkSize bytes along with a
+// manifest at their key. If less than one, DefaultChunkSize is used.
+// Get fails with ErrChunkIntegrity rather than returning a truncated
+// value if a chunk is missing or doesn't match the manifest, and Set
+// fails with ErrChunkedValueTooLarge if the value needs more than 1024
+// chunks. Values chunked with another chunk size can't be read back.
+// MetaSet stores values as they are, without chunking them.
+func (c *clientBuilder) WithChunking(chunkSize int) ClientBuilder {
+	c.chunking = true
+	c.chunkSize = chunkSize
+	return c
+}
+
+// WithEncryption encrypts the values of the items stored with
+// encryption, after their compression, bound to the key and flags
+// stored in the servers. The items found that can't be decrypted fail with
+// ErrDecryption, including the ones that aren't encrypted.
+func (c *clientBuilder) WithEncryption(encryption *Encryption) ClientBuilder {
+	c.encryption = encryption
+	return c
+}
+
+// WithTLSConfig wraps the connections to the servers in TLS with
+// config, for memcached 1.6 or later built with TLS support. Without
+// ServerName in config, the servers are verified with their host name
+// if they all share it, or else with their IP address. Without
+// ClientSessionCache, the client keeps the TLS sessions to resume them.
+func (c *clientBuilder) WithTLSConfig(config *tls.Config) ClientBuilder {
+	c.tlsConfig = config
+	return c
+}
+
 // Build builds the memcache client.
 func (c *clientBuilder) Build() Client {
+	cl, selector := c.build()
+	if c.metrics != nil {
+		if real, ok := cl.(*client); ok {
+			c.metrics.addPool(real.transport, c.getMaxIdleConns())
+		}
+	}
+
+	// The keys are mapped before the values are encrypted and the
+	// operations observed, so they see the keys of the servers.
+	var interceptors []Interceptor
+	if c.keyPrefix != "" {
+		interceptors = append(interceptors, newPrefixInterceptor(c.keyPrefix))
+	}
+	if c.normalize != nil {
+		interceptors = append(interceptors, newNormalizeInterceptor(c.normalize))
+	}
+	if c.encryption != nil {
+		interceptors = append(interceptors, c.encryption.interceptor())
+	}
+	if c.tracing {
+		interceptors = append(interceptors, newTracingInterceptor(c.tp, selector))
+	}
+	if c.metrics != nil {
+		interceptors = append(interceptors, c.metrics.interceptor(selector))
+	}
+	if c.logger != nil {
+		interceptors = append(interceptors, newLoggingInterceptor(c.logger, c.slow, c.redact, selector))
+	}
+	cl = Intercept(cl, interceptors...)
+
+	// The values are transformed before their keys are mapped, so the
+	// chunks are stored like any other item.
+	interceptors = append([]Interceptor(nil), c.interceptors...)
+	if c.compression != 0 {
+		interceptors = append(interceptors, newCompressionInterceptor(c.compression, c.threshold, c.getMaxValueSize()))
+	}
+	if c.chunking {
+		interceptors = append(interceptors, newChunkingInterceptor(cl, c.getChunkSize()))
+	}
+	return Intercept(cl, interceptors...)
+}
+
+// build builds the client and returns the selector of its servers, nil
+// for mocked and given clients.
+func (c *clientBuilder) build() (Client, memcache.ServerSelector) {
+	if c.client != nil {
+		if mock, ok := c.client.(*memcachemock.MockClient); ok && c.timeout > 0 {
+			return mock.WithTimeout(c.timeout), nil
+		}
+		return c.client, nil
+	}
 	if memcachemock.MockupServer.IsEnabled() {
-		return memcachemock.MockupServer.GetMockedClient()
+		return memcachemock.MockupServer.GetMockedClient().WithTimeout(c.getTimeout()), nil
+	}
+
+	selector := c.getServerSelector()
+	return &client{
+		transport:   c.getTransport(selector),
+		negativeTTL: c.negativeTTL,
+	}, selector
+}
+
+func (c *clientBuilder) getTransport(selector memcache.ServerSelector) transport {
+	if c.metaProtocol {
+		t := newMetaTransport(selector, c.getTimeout(), c.getMaxIdleConns())
+		t.tlsConfig = c.getTLSConfig()
+		return t
 	}
 
-	cli := memcache.New(c.servers...)
+	dial := new(net.Dialer).DialContext
+	if config := c.getTLSConfig(); config != nil {
+		dial = tlsDialer(dial, config)
+	}
+	conns := new(connCounter)
+	cli := memcache.NewFromSelector(selector)
 	cli.Timeout = c.getTimeout()
 	cli.MaxIdleConns = c.getMaxIdleConns()
+	cli.DialContext = conns.dialer(dial)
+	return &textTransport{mcClient: cli, conns: conns}
+}
 
-	return &client{cli}
+// getTLSConfig returns a copy of the TLS config, with the defaults of
+// WithTLSConfig, or nil without TLS.
+func (c *clientBuilder) getTLSConfig() *tls.Config {
+	if c.tlsConfig == nil {
+		return nil
+	}
+	config := c.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = tlsServerName(c.servers)
+	}
+	if config.ClientSessionCache == nil {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return config
+}
+
+// getServerSelector returns the selector of the servers. If they can't be
+// set, the selector returns the error from every call, so that the
+// operations of the client fail with it.
+func (c *clientBuilder) getServerSelector() memcache.ServerSelector {
+	if c.ketama {
+		ks := new(ketamaServerList)
+		if err := ks.setServers(c.servers, c.weights); err != nil {
+			return failedServerList{err: err}
+		}
+		return ks
+	}
+	if len(c.weights) > 0 {
+		return failedServerList{err: ErrServerWeights}
+	}
+	ss := new(memcache.ServerList)
+	if err := ss.SetServers(c.servers...); err != nil {
+		return failedServerList{err: err}
+	}
+	return ss
 }
 
 func (c *clientBuilder) getTimeout() time.Duration {
@@ -84,6 +453,22 @@ func (c *clientBuilder) getTimeout() time.Duration {
 	return c.timeout
 }
 
+// getMaxValueSize returns the length of the largest value the client can
+// store.
+func (c *clientBuilder) getMaxValueSize() int {
+	if c.chunking {
+		return maxChunks * c.getChunkSize()
+	}
+	return maxItemSize
+}
+
+func (c *clientBuilder) getChunkSize() int {
+	if c.chunkSize < 1 {
+		return DefaultChunkSize
+	}
+	return c.chunkSize
+}
+
 func (c *clientBuilder) getMaxIdleConns() int {
 	if c.maxIdleConns == 0 {
 		return DefaultMaxIdleConns
@@ -1,12 +1,20 @@
 package memcache
 
 import (
+	"crypto/tls"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/getmiranda/gomemcached/memcachemock"
 )
 
+var (
+	_ Client = (*memcachemock.FakeClient)(nil)
+	_ Client = (*memcachemock.MockClient)(nil)
+)
+
 func TestBuilder(t *testing.T) {
 
 	t.Run("NewBuilder", func(t *testing.T) {
@@ -40,6 +48,63 @@ func TestBuilder(t *testing.T) {
 		}
 	})
 
+	t.Run("UseKetama", func(t *testing.T) {
+		builder := clientBuilder{}
+		builder.UseKetama()
+		if !builder.ketama {
+			t.Errorf("Expected ketama to be %v, got %v", true, builder.ketama)
+		}
+	})
+
+	t.Run("WithServerWeights", func(t *testing.T) {
+		builder := clientBuilder{}
+		builder.WithServerWeights(map[string]int{"localhost:11211": 2})
+		if builder.weights["localhost:11211"] != 2 {
+			t.Errorf("Expected weight to be %v, got %v", 2, builder.weights["localhost:11211"])
+		}
+	})
+
+	t.Run("SetNegativeTTL", func(t *testing.T) {
+		builder := clientBuilder{}
+		builder.SetNegativeTTL(time.Second * 10)
+		if builder.negativeTTL != time.Second*10 {
+			t.Errorf("Expected negativeTTL to be %v, got %v", time.Second*10, builder.negativeTTL)
+		}
+	})
+
+	t.Run("UseMetaProtocol", func(t *testing.T) {
+		builder := clientBuilder{}
+		builder.UseMetaProtocol()
+		if _, ok := builder.getTransport(builder.getServerSelector()).(*metaTransport); !ok {
+			t.Errorf("Expected transport to be a meta transport")
+		}
+	})
+
+	t.Run("WithKeyPrefix", func(t *testing.T) {
+		builder := clientBuilder{}
+		builder.WithKeyPrefix("ns:")
+		if builder.keyPrefix != "ns:" {
+			t.Errorf("Expected keyPrefix to be %v, got %v", "ns:", builder.keyPrefix)
+		}
+	})
+
+	t.Run("WithTLSConfig", func(t *testing.T) {
+		config := &tls.Config{}
+		builder := clientBuilder{}
+		builder.WithServers("cache.example.com:11211").WithTLSConfig(config)
+
+		tlsConfig := builder.getTLSConfig()
+		if tlsConfig.ServerName != "cache.example.com" {
+			t.Errorf("Expected server name to be %v, got %v", "cache.example.com", tlsConfig.ServerName)
+		}
+		if tlsConfig.ClientSessionCache == nil {
+			t.Errorf("Expected a client session cache")
+		}
+		if config.ServerName != "" || config.ClientSessionCache != nil {
+			t.Errorf("Expected the given config to be left unchanged")
+		}
+	})
+
 	t.Run("Build", func(t *testing.T) {
 		builder := clientBuilder{}
 		client := builder.Build()
@@ -58,6 +123,82 @@ func TestBuilder(t *testing.T) {
 		}
 	})
 
+	t.Run("BuildWithClient", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		builder := clientBuilder{}
+		builder.WithClient(fake)
+
+		client := builder.Build()
+		if client != fake {
+			t.Errorf("Expected client to be the injected one")
+		}
+	})
+
+	t.Run("BuildWithMockServer", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachemock.NewMockServer(t)
+		server.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationExists,
+			Args:      memcachemock.Args{"key"},
+
+			Return: true,
+		})
+
+		client := NewBuilder().WithClient(server.GetMockedClient()).Build()
+		exists, err := client.Exists("key")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !exists {
+			t.Errorf("Expected key to exist")
+		}
+	})
+
+	t.Run("BuildWithMockServerTimeout", func(t *testing.T) {
+		t.Parallel()
+
+		server := memcachemock.NewMockServer(t)
+		server.ExpectPing()
+		server.InjectFault(memcachemock.Fault{Delay: time.Second})
+
+		client := NewBuilder().WithClient(server.GetMockedClient()).SetTimeout(time.Millisecond * 10).Build()
+		start := time.Now()
+		if err := client.Ping(); !errors.Is(err, memcachemock.ErrTimeout) {
+			t.Errorf("Expected error to be %v, got %v", memcachemock.ErrTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+			t.Errorf("Expected call to time out after %v, got %v", time.Millisecond*10, elapsed)
+		}
+	})
+
+	t.Run("getServerSelector", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			builder *clientBuilder
+			err     error
+		}{
+			{"ServerList", &clientBuilder{servers: []string{"127.0.0.1:11211"}}, nil},
+			{"Ketama", &clientBuilder{servers: []string{"127.0.0.1:11211"}, ketama: true}, nil},
+			{"WeightsWithoutKetama", &clientBuilder{servers: []string{"127.0.0.1:11211"}, weights: map[string]int{"127.0.0.1:11211": 2}}, ErrServerWeights},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := tt.builder.getServerSelector().PickServer("key")
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Expected error to be %v, got %v", tt.err, err)
+				}
+			})
+		}
+
+		for _, ketama := range []bool{false, true} {
+			builder := &clientBuilder{servers: []string{"127.0.0.1:notaport"}, ketama: ketama}
+			if _, err := builder.getServerSelector().PickServer("key"); err == nil || errors.Is(err, memcache.ErrNoServers) {
+				t.Errorf("Expected the error of the invalid server, got %v", err)
+			}
+		}
+	})
+
 	t.Run("getTimeoutDefault", func(t *testing.T) {
 		builder := clientBuilder{}
 		timeout := builder.getTimeout()
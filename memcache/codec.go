@@ -0,0 +1,124 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Codec identifiers recorded in the low byte of item.Item.Flags.
+const (
+	CodecJSON  uint32 = 1
+	CodecGob   uint32 = 2
+	CodecProto uint32 = 3
+)
+
+// codecFlagsMask selects the bits of item.Item.Flags that hold the codec
+// identifier. The remaining bits are left for other uses.
+const codecFlagsMask uint32 = 0xff
+
+var (
+	ErrCodecMismatch   = errors.New("memcache: codec mismatch")
+	ErrNotProtoMessage = errors.New("memcache: value is not a proto message")
+)
+
+// Codec encodes and decodes the values stored by a TypedCache.
+type Codec interface {
+	// ID identifies the codec. It is stored in the item flags so that
+	// values written with a different codec are rejected on read.
+	// It must fit in a byte and must not be zero.
+	ID() uint32
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ProtoMessage is implemented by protobuf-style generated messages that
+// know how to marshal and unmarshal themselves.
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec struct{}
+
+// ID returns CodecJSON.
+func (JSONCodec) ID() uint32 {
+	return CodecJSON
+}
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON data into the value pointed to by v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec struct{}
+
+// ID returns CodecGob.
+func (GobCodec) ID() uint32 {
+	return CodecGob
+}
+
+// Marshal returns the gob encoding of v.
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal decodes the gob data into the value pointed to by v.
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec encodes values implementing ProtoMessage.
+type ProtoCodec struct{}
+
+// ID returns CodecProto.
+func (ProtoCodec) ID() uint32 {
+	return CodecProto
+}
+
+// Marshal returns the encoding of v, which must implement ProtoMessage.
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return msg.Marshal()
+}
+
+// Unmarshal decodes data into v, which must either implement ProtoMessage
+// or be a pointer to a ProtoMessage pointer. In the latter case a new
+// message is allocated when the pointer is nil.
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	if msg, ok := v.(ProtoMessage); ok {
+		return msg.Unmarshal(data)
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	if rv.Elem().IsNil() {
+		rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+	}
+	msg, ok := rv.Elem().Interface().(ProtoMessage)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return msg.Unmarshal(data)
+}
@@ -0,0 +1,199 @@
+package memcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is an algorithm compressing the values of the items,
+// recorded in bits 8 to 15 of item.Item.Flags.
+type Compression uint32
+
+// Compression algorithms.
+const (
+	CompressionGzip   Compression = 1
+	CompressionZstd   Compression = 2
+	CompressionSnappy Compression = 3
+)
+
+// compressionFlagsShift and compressionFlagsMask locate the compression
+// algorithm in item.Item.Flags, right above the codec identifier.
+const (
+	compressionFlagsShift        = 8
+	compressionFlagsMask  uint32 = 0xff << compressionFlagsShift
+)
+
+// maxItemSize is the default item size limit of memcached, which bounds
+// the decompressed values of the clients without chunking.
+const maxItemSize = 1024 * 1024
+
+// ErrUnknownCompression is returned when an item is compressed with an
+// unknown algorithm.
+var ErrUnknownCompression = errors.New("memcache: unknown compression")
+
+// ErrDecompressedTooLarge is returned when the value of an item is larger
+// than the client can store once decompressed.
+var ErrDecompressedTooLarge = errors.New("memcache: decompressed value is too large")
+
+var (
+	zstdOnce     sync.Once
+	zstdEncoder  *zstd.Encoder
+	zstdDecoders sync.Map // map[int]*zstd.Decoder
+)
+
+// sharedZstdEncoder returns the zstd encoder shared by the clients.
+func sharedZstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+	})
+	return zstdEncoder
+}
+
+// sharedZstdDecoder returns the zstd decoder of values of at most limit
+// bytes shared by the clients.
+func sharedZstdDecoder(limit int) *zstd.Decoder {
+	if decoder, ok := zstdDecoders.Load(limit); ok {
+		return decoder.(*zstd.Decoder)
+	}
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(limit)))
+	shared, loaded := zstdDecoders.LoadOrStore(limit, decoder)
+	if loaded {
+		decoder.Close()
+	}
+	return shared.(*zstd.Decoder)
+}
+
+// compress returns the compression of data.
+func (c Compression) compress(data []byte) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		var buffer bytes.Buffer
+		w := gzip.NewWriter(&buffer)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case CompressionZstd:
+		return sharedZstdEncoder().EncodeAll(data, nil), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, data), nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+// decompress returns the decompression of data, which fails with
+// ErrDecompressedTooLarge if it is longer than limit bytes.
+func (c Compression) decompress(data []byte, limit int) ([]byte, error) {
+	switch c {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		value, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err == nil && len(value) > limit {
+			return nil, ErrDecompressedTooLarge
+		}
+		return value, err
+	case CompressionZstd:
+		value, err := sharedZstdDecoder(limit).DecodeAll(data, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return nil, ErrDecompressedTooLarge
+		}
+		return value, err
+	case CompressionSnappy:
+		// The length of the value is read before decoding it.
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, ErrDecompressedTooLarge
+		}
+		return snappy.Decode(nil, data)
+	}
+	return nil, ErrUnknownCompression
+}
+
+// newCompressionInterceptor returns an interceptor compressing with
+// compression the values of the items stored longer than threshold bytes,
+// unless it doesn't make them shorter, and decompressing the values of the
+// items found with any algorithm, up to limit bytes. Values without
+// compression are given back as they are.
+func newCompressionInterceptor(compression Compression, threshold, limit int) Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		if rawValues(ctx) {
+			return invoker(ctx, call)
+		}
+		switch call.Operation {
+		case "Set", "Add", "Replace", "CompareAndSwap", "MetaSet":
+			if call.Item != nil && len(call.Item.Value) > threshold && call.Item.Flags&compressionFlagsMask == 0 {
+				value, err := compression.compress(call.Item.Value)
+				if err != nil {
+					return err
+				}
+				if len(value) < len(call.Item.Value) {
+					it := *call.Item
+					it.Value = value
+					it.Flags |= uint32(compression) << compressionFlagsShift
+					call.Item = &it
+				}
+			}
+			return invoker(ctx, call)
+		}
+
+		err := invoker(ctx, call)
+		if call.Item != nil && (call.Operation == "Get" || call.Operation == "MetaGet") {
+			it, derr := decompressItem(call.Item, limit)
+			if derr != nil {
+				call.Item = nil
+				return derr
+			}
+			call.Item = it
+		}
+		if len(call.Items) > 0 {
+			items := make(map[string]*item.Item, len(call.Items))
+			for key, it := range call.Items {
+				it, derr := decompressItem(it, limit)
+				if derr != nil {
+					// The other items are given back along with the error.
+					if err == nil {
+						err = derr
+					}
+					continue
+				}
+				items[key] = it
+			}
+			call.Items = items
+		}
+		return err
+	}
+}
+
+// decompressItem returns it, or a copy of it with its value decompressed
+// if it is compressed, of at most limit bytes.
+func decompressItem(it *item.Item, limit int) (*item.Item, error) {
+	compression := Compression((it.Flags & compressionFlagsMask) >> compressionFlagsShift)
+	if compression == 0 {
+		return it, nil
+	}
+	value, err := compression.decompress(it.Value, limit)
+	if err != nil {
+		return nil, err
+	}
+	cp := *it
+	cp.Value = value
+	cp.Flags &^= compressionFlagsMask
+	return &cp, nil
+}
@@ -0,0 +1,189 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestCompression(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"foo","value":"bar"},`), 100)
+
+	algorithms := []struct {
+		name        string
+		compression Compression
+	}{
+		{"Gzip", CompressionGzip},
+		{"Zstd", CompressionZstd},
+		{"Snappy", CompressionSnappy},
+	}
+	for _, algorithm := range algorithms {
+		compression := algorithm.compression
+		t.Run(algorithm.name, func(t *testing.T) {
+			fake := memcachemock.NewFakeClient()
+			client := NewBuilder().
+				WithClient(fake).
+				WithCompression(compression, 64).
+				Build()
+
+			if err := client.Set(&item.Item{Key: "foo", Value: value, Flags: CodecJSON}); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			stored, err := fake.Get("foo")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(stored.Value) >= len(value) {
+				t.Errorf("Expected value of %v bytes to be compressed, got %v bytes", len(value), len(stored.Value))
+			}
+			if expected := uint32(compression)<<8 | CodecJSON; stored.Flags != expected {
+				t.Errorf("Expected flags to be %#x, got %#x", expected, stored.Flags)
+			}
+
+			it, err := client.Get("foo")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !bytes.Equal(it.Value, value) {
+				t.Errorf("Expected value to be decompressed")
+			}
+			if it.Flags != CodecJSON {
+				t.Errorf("Expected flags to be %#x, got %#x", CodecJSON, it.Flags)
+			}
+
+			items, err := client.GetMulti([]string{"foo"})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !bytes.Equal(items["foo"].Value, value) {
+				t.Errorf("Expected value to be decompressed")
+			}
+
+			cached, err := client.GetOrLoad(context.Background(), "foo", time.Minute, func() ([]byte, error) {
+				t.Errorf("Expected loader not to be called")
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !bytes.Equal(cached, value) {
+				t.Errorf("Expected value to be decompressed")
+			}
+
+			loaded, err := client.GetOrLoad(context.Background(), "loaded", time.Minute, func() ([]byte, error) {
+				return value, nil
+			})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !bytes.Equal(loaded, value) {
+				t.Errorf("Expected loaded value to be given back")
+			}
+			stored, err = fake.Get("loaded")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(stored.Value) >= len(value) || stored.Flags != uint32(compression)<<8 {
+				t.Errorf("Expected loaded value to be stored compressed")
+			}
+		})
+	}
+
+	t.Run("Threshold", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := NewBuilder().
+			WithClient(fake).
+			WithCompression(CompressionZstd, len(value)).
+			Build()
+
+		client.Set(&item.Item{Key: "foo", Value: value})
+		stored, _ := fake.Get("foo")
+		if !bytes.Equal(stored.Value, value) || stored.Flags != 0 {
+			t.Errorf("Expected value under the threshold to be stored as is")
+		}
+	})
+
+	t.Run("Passthrough", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.Set(&item.Item{Key: "foo", Value: value, Flags: CodecGob})
+		client := NewBuilder().
+			WithClient(fake).
+			WithCompression(CompressionGzip, 0).
+			Build()
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) || it.Flags != CodecGob {
+			t.Errorf("Expected uncompressed item to be given back as is")
+		}
+	})
+
+	t.Run("UnknownCompression", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.Set(&item.Item{Key: "foo", Value: value, Flags: 0x7f << 8})
+		client := NewBuilder().
+			WithClient(fake).
+			WithCompression(CompressionGzip, 0).
+			Build()
+
+		if _, err := client.Get("foo"); !errors.Is(err, ErrUnknownCompression) {
+			t.Errorf("Expected error to be %v, got %v", ErrUnknownCompression, err)
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.Set(&item.Item{Key: "broken", Value: value, Flags: 0x7f << 8})
+		client := NewBuilder().
+			WithClient(fake).
+			WithCompression(CompressionGzip, 0).
+			Build()
+		client.Set(&item.Item{Key: "foo", Value: value})
+
+		items, err := client.GetMulti([]string{"foo", "broken"})
+		if !errors.Is(err, ErrUnknownCompression) {
+			t.Errorf("Expected error to be %v, got %v", ErrUnknownCompression, err)
+		}
+		if len(items) != 1 || !bytes.Equal(items["foo"].Value, value) {
+			t.Errorf("Expected the other items to be given back, got %v items", len(items))
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		large := make([]byte, maxItemSize+1)
+		for _, algorithm := range algorithms {
+			compressed, err := algorithm.compression.compress(large)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			fake := memcachemock.NewFakeClient()
+			fake.Set(&item.Item{Key: "foo", Value: compressed, Flags: uint32(algorithm.compression) << 8})
+
+			client := NewBuilder().
+				WithClient(fake).
+				WithCompression(algorithm.compression, 0).
+				Build()
+			if _, err := client.Get("foo"); !errors.Is(err, ErrDecompressedTooLarge) {
+				t.Errorf("%v: Expected error to be %v, got %v", algorithm.name, ErrDecompressedTooLarge, err)
+			}
+
+			// Chunked values may be larger than the item size limit.
+			client = NewBuilder().
+				WithClient(fake).
+				WithCompression(algorithm.compression, 0).
+				WithChunking(0).
+				Build()
+			if it, err := client.Get("foo"); err != nil || !bytes.Equal(it.Value, large) {
+				t.Errorf("%v: Expected value to be decompressed, got %v", algorithm.name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package memcache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// flagEncrypted marks an item whose value is encrypted.
+const flagEncrypted uint32 = 1 << 29
+
+// encryptionVersion starts the envelope of the encrypted values, followed
+// by the ID of the key, the nonce and the ciphertext. Values of the
+// first version, bound to their key only, aren't decrypted anymore.
+const encryptionVersion byte = 2
+
+var (
+	// ErrDecryption is returned when the value of an item isn't encrypted,
+	// was encrypted with an unknown key, or fails authentication, such as
+	// when it was tampered with or moved to another key.
+	ErrDecryption = errors.New("memcache: value can't be decrypted")
+	// ErrDuplicateKeyID is returned by NewEncryption when two keys have
+	// the same ID.
+	ErrDuplicateKeyID = errors.New("memcache: duplicate encryption key ID")
+)
+
+// EncryptionKey is an AES key of 16, 24 or 32 bytes and its ID, recorded
+// with the values it encrypts.
+type EncryptionKey struct {
+	ID  uint8
+	Key []byte
+}
+
+// Encryption encrypts the values of the items with AES-GCM, bound to
+// their key and flags.
+type Encryption struct {
+	current uint8
+	aeads   map[uint8]cipher.AEAD
+}
+
+// NewEncryption creates an encryption encrypting the values with current,
+// and decrypting them with current or any of the previous keys, so that
+// keys can be rotated.
+func NewEncryption(current EncryptionKey, previous ...EncryptionKey) (*Encryption, error) {
+	e := &Encryption{
+		current: current.ID,
+		aeads:   make(map[uint8]cipher.AEAD),
+	}
+	for _, key := range append([]EncryptionKey{current}, previous...) {
+		if _, ok := e.aeads[key.ID]; ok {
+			return nil, ErrDuplicateKeyID
+		}
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads[key.ID] = aead
+	}
+	return e, nil
+}
+
+// additionalData returns the data authenticated along with the value at
+// key with flags, without flagEncrypted, so that neither can be changed in
+// the servers without failing the decryption.
+func additionalData(key string, flags uint32) []byte {
+	data := make([]byte, 4, 4+len(key))
+	binary.BigEndian.PutUint32(data, flags&^flagEncrypted)
+	return append(data, key...)
+}
+
+// encrypt returns the envelope of the value at key with flags.
+func (e *Encryption) encrypt(key string, flags uint32, value []byte) ([]byte, error) {
+	aead := e.aeads[e.current]
+	envelope := make([]byte, 2+aead.NonceSize(), 2+aead.NonceSize()+len(value)+aead.Overhead())
+	envelope[0] = encryptionVersion
+	envelope[1] = e.current
+	nonce := envelope[2:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(envelope, nonce, value, additionalData(key, flags)), nil
+}
+
+// decrypt returns the value at key with flags in envelope.
+func (e *Encryption) decrypt(key string, flags uint32, envelope []byte) ([]byte, error) {
+	if len(envelope) < 2 || envelope[0] != encryptionVersion {
+		return nil, ErrDecryption
+	}
+	aead, ok := e.aeads[envelope[1]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key ID %d", ErrDecryption, envelope[1])
+	}
+	if len(envelope) < 2+aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	nonce, ciphertext := envelope[2:2+aead.NonceSize()], envelope[2+aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, additionalData(key, flags))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return value, nil
+}
+
+// decryptItem returns a copy of it with its value decrypted. Items that
+// aren't encrypted are rejected.
+func (e *Encryption) decryptItem(it *item.Item) (*item.Item, error) {
+	if it.Flags&flagEncrypted == 0 {
+		return nil, ErrDecryption
+	}
+	value, err := e.decrypt(it.Key, it.Flags, it.Value)
+	if err != nil {
+		return nil, err
+	}
+	cp := *it
+	cp.Value = value
+	cp.Flags &^= flagEncrypted
+	return &cp, nil
+}
+
+// interceptor returns an interceptor encrypting the values of the items
+// stored, and decrypting the values of the items found.
+func (e *Encryption) interceptor() Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		if rawValues(ctx) {
+			return invoker(ctx, call)
+		}
+		switch call.Operation {
+		case "Set", "Add", "Replace", "CompareAndSwap", "MetaSet":
+			if call.Item != nil {
+				value, err := e.encrypt(call.Item.Key, call.Item.Flags, call.Item.Value)
+				if err != nil {
+					return err
+				}
+				it := *call.Item
+				it.Value = value
+				it.Flags |= flagEncrypted
+				call.Item = &it
+			}
+			return invoker(ctx, call)
+		}
+
+		err := invoker(ctx, call)
+		if call.Item != nil && (call.Operation == "Get" || call.Operation == "MetaGet") {
+			it, derr := e.decryptItem(call.Item)
+			if derr != nil {
+				call.Item = nil
+				return derr
+			}
+			call.Item = it
+		}
+		if len(call.Items) > 0 {
+			items := make(map[string]*item.Item, len(call.Items))
+			for key, it := range call.Items {
+				it, derr := e.decryptItem(it)
+				if derr != nil {
+					// The other items are given back along with the error.
+					if err == nil {
+						err = derr
+					}
+					continue
+				}
+				items[key] = it
+			}
+			call.Items = items
+		}
+		return err
+	}
+}
@@ -0,0 +1,172 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestEncryption(t *testing.T) {
+	key1 := EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)}
+	key2 := EncryptionKey{ID: 2, Key: bytes.Repeat([]byte{2}, 16)}
+	value := []byte(`{"email":"user@example.com"}`)
+
+	newEncryptedClient := func(t *testing.T, fake *memcachemock.FakeClient, current EncryptionKey, previous ...EncryptionKey) Client {
+		encryption, err := NewEncryption(current, previous...)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return NewBuilder().WithClient(fake).WithEncryption(encryption).Build()
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := newEncryptedClient(t, fake, key1)
+		if err := client.Set(&item.Item{Key: "foo", Value: value, Flags: CodecJSON}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		stored, _ := fake.Get("foo")
+		if bytes.Contains(stored.Value, []byte("user@example.com")) {
+			t.Errorf("Expected value to be encrypted")
+		}
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) || it.Flags != CodecJSON {
+			t.Errorf("Expected item to be decrypted, got %q with flags %#x", it.Value, it.Flags)
+		}
+	})
+
+	t.Run("FailClosed", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := newEncryptedClient(t, fake, key1)
+		client.Set(&item.Item{Key: "foo", Value: value})
+		stored, _ := fake.Get("foo")
+
+		tampered := append([]byte(nil), stored.Value...)
+		tampered[len(tampered)-1] ^= 1
+		fake.Set(&item.Item{Key: "tampered", Value: tampered, Flags: stored.Flags})
+		fake.Set(&item.Item{Key: "swapped", Value: stored.Value, Flags: stored.Flags})
+		fake.Set(&item.Item{Key: "plaintext", Value: value})
+		client.Set(&item.Item{Key: "reflagged", Value: value})
+		reflagged, _ := fake.Get("reflagged")
+		reflagged.Flags |= CodecJSON
+		fake.Set(reflagged)
+
+		for _, key := range []string{"tampered", "swapped", "plaintext", "reflagged"} {
+			if _, err := client.Get(key); !errors.Is(err, ErrDecryption) {
+				t.Errorf("Expected error of %v to be %v, got %v", key, ErrDecryption, err)
+			}
+		}
+
+		items, err := client.GetMulti([]string{"foo", "swapped"})
+		if !errors.Is(err, ErrDecryption) {
+			t.Errorf("Expected error to be %v, got %v", ErrDecryption, err)
+		}
+		if len(items) != 1 || items["foo"] == nil {
+			t.Errorf("Expected only the item %v to be given back, got %v items", "foo", len(items))
+		}
+	})
+
+	t.Run("GetOrLoad", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := newEncryptedClient(t, fake, key1)
+		ctx := context.Background()
+
+		loaded, err := client.GetOrLoad(ctx, "loaded", time.Minute, func() ([]byte, error) {
+			return value, nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(loaded, value) {
+			t.Errorf("Expected value to be %q, got %q", value, loaded)
+		}
+		stored, _ := fake.Get("loaded")
+		if stored.Flags&flagEncrypted == 0 || bytes.Contains(stored.Value, []byte("user@example.com")) {
+			t.Errorf("Expected loaded value to be stored encrypted")
+		}
+		it, err := client.Get("loaded")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) {
+			t.Errorf("Expected value to be %q, got %q", value, it.Value)
+		}
+
+		client.Set(&item.Item{Key: "set", Value: value})
+		cached, err := client.GetOrLoad(ctx, "set", time.Minute, func() ([]byte, error) {
+			t.Errorf("Expected loader not to be called")
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(cached, value) {
+			t.Errorf("Expected value to be %q, got %q", value, cached)
+		}
+
+		fake.Set(&item.Item{Key: "plaintext", Value: value})
+		if _, err := client.GetOrLoad(ctx, "plaintext", time.Minute, func() ([]byte, error) {
+			return value, nil
+		}); !errors.Is(err, ErrDecryption) {
+			t.Errorf("Expected error to be %v, got %v", ErrDecryption, err)
+		}
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		newEncryptedClient(t, fake, key1).Set(&item.Item{Key: "foo", Value: value})
+
+		rotated := newEncryptedClient(t, fake, key2, key1)
+		it, err := rotated.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, value) {
+			t.Errorf("Expected value to be decrypted with the previous key")
+		}
+
+		if _, err := newEncryptedClient(t, fake, key2).Get("foo"); !errors.Is(err, ErrDecryption) {
+			t.Errorf("Expected error to be %v, got %v", ErrDecryption, err)
+		}
+	})
+
+	t.Run("NewEncryption", func(t *testing.T) {
+		if _, err := NewEncryption(EncryptionKey{ID: 1, Key: []byte("short")}); err == nil {
+			t.Errorf("Expected an error for an invalid key size, got nil")
+		}
+		if _, err := NewEncryption(key1, EncryptionKey{ID: 1, Key: key2.Key}); !errors.Is(err, ErrDuplicateKeyID) {
+			t.Errorf("Expected error to be %v, got %v", ErrDuplicateKeyID, err)
+		}
+	})
+
+	t.Run("Chunking", func(t *testing.T) {
+		encryption, _ := NewEncryption(key1)
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithEncryption(encryption).
+			WithChunking(100).
+			Build()
+
+		large := bytes.Repeat(value, 20)
+		if err := client.Set(&item.Item{Key: "foo", Value: large}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, large) {
+			t.Errorf("Expected value to be reassembled and decrypted")
+		}
+	})
+}
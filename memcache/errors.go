@@ -0,0 +1,56 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// errorTypes are the classifications of the known errors.
+var errorTypes = []struct {
+	err  error
+	name string
+}{
+	{memcache.ErrCacheMiss, "cache_miss"},
+	{memcache.ErrCASConflict, "cas_conflict"},
+	{memcache.ErrNotStored, "not_stored"},
+	{memcache.ErrServerError, "server_error"},
+	{memcache.ErrNoStats, "no_stats"},
+	{memcache.ErrMalformedKey, "malformed_key"},
+	{memcache.ErrNoServers, "no_servers"},
+	{ErrProtocol, "protocol_error"},
+	{ErrMetaProtocolRequired, "meta_protocol_required"},
+	{ErrNotFound, "not_found"},
+	{ErrNamespaceVersion, "namespace_version"},
+	{ErrDecompressedTooLarge, "decompressed_too_large"},
+	{ErrChunkIntegrity, "chunk_integrity"},
+	{ErrChunkedValueTooLarge, "chunked_value_too_large"},
+	{ErrDecryption, "decryption"},
+	{ErrServerWeights, "server_weights"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "deadline_exceeded"},
+}
+
+// errorType classifies an error with a low cardinality name.
+func errorType(err error) string {
+	for _, t := range errorTypes {
+		if errors.Is(err, t.err) {
+			return t.name
+		}
+	}
+	var connectTimeout *memcache.ConnectTimeoutError
+	if errors.As(err, &connectTimeout) {
+		return "connect_timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network_error"
+	}
+	return fmt.Sprintf("%T", err)
+}
@@ -0,0 +1,85 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"golang.org/x/sync/singleflight"
+)
+
+// flagNegative marks an item stored by GetOrLoad to remember that the
+// loader reported ErrNotFound.
+const flagNegative uint32 = 1 << 31
+
+// ErrNotFound is returned by a GetOrLoad loader to report that the value
+// doesn't exist. When a negative TTL is configured, the result is cached
+// and GetOrLoad keeps returning ErrNotFound without calling the loader
+// until it expires.
+var ErrNotFound = errors.New("memcache: not found")
+
+// GetOrLoad gets the value for the given key. On a cache miss the value
+// is obtained from loader and stored with the given ttl. Concurrent
+// misses for the same key are collapsed into a single loader call.
+func (c *client) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	return getOrLoad(ctx, c, &c.loadGroup, c.negativeTTL, key, ttl, loader)
+}
+
+// getOrLoad implements GetOrLoad with the Get and Set of client, so that
+// the values go through the same path as the other operations. Concurrent
+// misses for the same key are collapsed with group.
+func getOrLoad(ctx context.Context, client Client, group *singleflight.Group, negativeTTL time.Duration, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	it, err := client.GetContext(ctx, key)
+	if err == nil {
+		if it.Flags&flagNegative != 0 {
+			return nil, ErrNotFound
+		}
+		return it.Value, nil
+	}
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, err
+	}
+
+	ch := group.DoChan(key, func() (interface{}, error) {
+		return load(client, negativeTTL, key, ttl, loader)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load calls loader and stores its result with client. The result is
+// shared by every caller waiting on the same key, so it doesn't depend on
+// any of their contexts. Failing to store the value doesn't fail the load.
+func load(client Client, negativeTTL time.Duration, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	value, err := loader()
+	if errors.Is(err, ErrNotFound) {
+		if negativeTTL > 0 {
+			_ = client.Set(&item.Item{
+				Key:        key,
+				Flags:      flagNegative,
+				Expiration: expiration(negativeTTL),
+			})
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_ = client.Set(&item.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: expiration(ttl),
+	})
+	return value, nil
+}
@@ -0,0 +1,224 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/memcachemock"
+	"github.com/getmiranda/gomemcached/memcachetest"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	if memcachemock.MockupServer.IsEnabled() {
+		memcachemock.MockupServer.Stop()
+		defer memcachemock.MockupServer.Start()
+	}
+
+	newClient := func(t *testing.T, negativeTTL time.Duration) (Client, *memcachetest.Server) {
+		server := memcachetest.NewServer(t)
+		return NewBuilder().WithServers(server.Addr()).SetNegativeTTL(negativeTTL).Build(), server
+	}
+
+	t.Run("ConcurrentMisses", func(t *testing.T) {
+		client, _ := newClient(t, 0)
+
+		var calls int32
+		release := make(chan struct{})
+		loader := func() ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return []byte("bar"), nil
+		}
+
+		const callers = 10
+		var wg sync.WaitGroup
+		values := make([][]byte, callers)
+		errs := make([]error, callers)
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				values[i], errs[i] = client.GetOrLoad(context.Background(), "foo", time.Minute, loader)
+			}(i)
+		}
+		// Give every caller the time to miss and wait on the load.
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("Expected loader to be called %v time, got %v", 1, n)
+		}
+		for i := range values {
+			if errs[i] != nil {
+				t.Errorf("Expected no error, got %v", errs[i])
+			}
+			if !bytes.Equal(values[i], []byte("bar")) {
+				t.Errorf("Expected value to be %q, got %q", "bar", values[i])
+			}
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		client, server := newClient(t, 0)
+
+		value, err := client.GetOrLoad(context.Background(), "foo", 10*time.Second, func() ([]byte, error) {
+			return []byte("bar"), nil
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(value, []byte("bar")) {
+			t.Errorf("Expected value to be %q, got %q", "bar", value)
+		}
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, []byte("bar")) {
+			t.Errorf("Expected stored value to be %q, got %q", "bar", it.Value)
+		}
+
+		server.Advance(11 * time.Second)
+		if _, err := client.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("NegativeCaching", func(t *testing.T) {
+		client, server := newClient(t, 10*time.Second)
+
+		var calls int32
+		loader := func() ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, ErrNotFound
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := client.GetOrLoad(context.Background(), "foo", time.Minute, loader); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected error to be %v, got %v", ErrNotFound, err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("Expected loader to be called %v time, got %v", 1, n)
+		}
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Flags&flagNegative == 0 {
+			t.Errorf("Expected flags %#x to be set, got %#x", flagNegative, it.Flags)
+		}
+
+		server.Advance(11 * time.Second)
+		if _, err := client.GetOrLoad(context.Background(), "foo", time.Minute, loader); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrNotFound, err)
+		}
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("Expected loader to be called %v times, got %v", 2, n)
+		}
+	})
+
+	t.Run("NoNegativeTTL", func(t *testing.T) {
+		client, _ := newClient(t, 0)
+
+		client.GetOrLoad(context.Background(), "foo", time.Minute, func() ([]byte, error) {
+			return nil, ErrNotFound
+		})
+		if _, err := client.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		client, _ := newClient(t, 0)
+
+		var calls int32
+		release := make(chan struct{})
+		loader := func() ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return []byte("bar"), nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		canceled := make(chan error, 1)
+		go func() {
+			_, err := client.GetOrLoad(ctx, "foo", time.Minute, loader)
+			canceled <- err
+		}()
+		waiting := make(chan []byte, 1)
+		go func() {
+			value, _ := client.GetOrLoad(context.Background(), "foo", time.Minute, loader)
+			waiting <- value
+		}()
+		// Give both callers the time to miss and wait on the load.
+		time.Sleep(100 * time.Millisecond)
+
+		cancel()
+		select {
+		case err := <-canceled:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected error to be %v, got %v", context.Canceled, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected GetOrLoad to return when its context is canceled")
+		}
+
+		// The load goes on for the other caller and stores its value.
+		close(release)
+		if value := <-waiting; !bytes.Equal(value, []byte("bar")) {
+			t.Errorf("Expected value to be %q, got %q", "bar", value)
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("Expected loader to be called %v time, got %v", 1, n)
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bytes.Equal(it.Value, []byte("bar")) {
+			t.Errorf("Expected stored value to be %q, got %q", "bar", it.Value)
+		}
+	})
+
+	t.Run("FakeClient", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.SetNegativeTTL(10 * time.Second)
+
+		var calls int32
+		loader := func() ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, ErrNotFound
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := fake.GetOrLoad(context.Background(), "foo", time.Minute, loader); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected error to be %v, got %v", ErrNotFound, err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("Expected loader to be called %v time, got %v", 1, n)
+		}
+		fake.Advance(11 * time.Second)
+		fake.GetOrLoad(context.Background(), "foo", time.Minute, loader)
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("Expected loader to be called %v times, got %v", 2, n)
+		}
+
+		fake.GetOrLoad(context.Background(), "bar", 500*time.Millisecond, func() ([]byte, error) {
+			return []byte("bar"), nil
+		})
+		fake.Advance(2 * time.Second)
+		if _, err := fake.Get("bar"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+}
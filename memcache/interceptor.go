@@ -0,0 +1,345 @@
+package memcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+	"golang.org/x/sync/singleflight"
+)
+
+// Call is an operation of the client going through interceptors.
+type Call struct {
+	// Operation is the name of the client method, without its Context
+	// suffix.
+	Operation string
+	// Keys are the keys of the operation, the key of the item for the
+	// operations taking one. Interceptors may change the keys of the
+	// operations taking keys before invoking them, except GetOrLoad,
+	// which runs Get and Set with the key of the caller.
+	Keys []string
+	// Item is the item of the operations taking one, which interceptors
+	// may replace before invoking them, or the item found by Get and
+	// MetaGet once invoked.
+	Item *item.Item
+	// Seconds is the expiration given to Touch.
+	Seconds int32
+
+	// Items are the items found by GetMulti once invoked.
+	Items map[string]*item.Item
+	// Exists is the result of Exists once invoked.
+	Exists bool
+	// Value is the result of GetOrLoad once invoked.
+	Value []byte
+	// NewValue is the result of Increment, Decrement and MetaArithmetic
+	// once invoked.
+	NewValue uint64
+	// Meta is the metadata returned by the meta operations once invoked.
+	Meta *item.Meta
+}
+
+// Invoker runs an operation.
+type Invoker func(ctx context.Context, call *Call) error
+
+// Interceptor intercepts the operations of a client. It runs the
+// operation by calling invoker, and may inspect or change the call before
+// and after.
+type Interceptor func(ctx context.Context, call *Call, invoker Invoker) error
+
+// rawValuesKey is the context key of withRawValues.
+type rawValuesKey struct{}
+
+// withRawValues returns a context whose operations store and read the
+// values as they are, without compressing, chunking or encrypting them.
+func withRawValues(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawValuesKey{}, true)
+}
+
+// rawValues reports whether the values of the operations run with ctx
+// are stored and read as they are.
+func rawValues(ctx context.Context) bool {
+	raw, _ := ctx.Value(rawValuesKey{}).(bool)
+	return raw
+}
+
+// Intercept returns a client running the operations of client through
+// the interceptors. The first interceptor is the outermost.
+func Intercept(client Client, interceptors ...Interceptor) Client {
+	if len(interceptors) == 0 {
+		return client
+	}
+	return &interceptedClient{
+		next:         client,
+		interceptors: interceptors,
+		negativeTTL:  negativeTTL(client),
+	}
+}
+
+// negativeTTL returns the negative TTL of GetOrLoad of a client built by
+// ClientBuilder, zero for other clients.
+func negativeTTL(cl Client) time.Duration {
+	switch cl := cl.(type) {
+	case *client:
+		return cl.negativeTTL
+	case *interceptedClient:
+		return cl.negativeTTL
+	}
+	return 0
+}
+
+// interceptedClient runs the operations of the next client through
+// interceptors.
+type interceptedClient struct {
+	next         Client
+	interceptors []Interceptor
+	negativeTTL  time.Duration
+	loadGroup    singleflight.Group
+}
+
+// intercept runs call through the interceptors, invoke running it on the
+// next client.
+func (c *interceptedClient) intercept(ctx context.Context, call *Call, invoke Invoker) error {
+	invoker := invoke
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoker
+		invoker = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return invoker(ctx, call)
+}
+
+// interceptItem runs an operation on an item through the interceptors.
+// The CAS ID of an item replaced by the interceptors is copied back to
+// the original item.
+func (c *interceptedClient) interceptItem(ctx context.Context, op string, it *item.Item, invoke Invoker) (*Call, error) {
+	call := &Call{Operation: op, Item: it}
+	if it != nil {
+		call.Keys = []string{it.Key}
+	}
+	err := c.intercept(ctx, call, invoke)
+	if it != nil && call.Item != nil && call.Item != it {
+		it.CasID = call.Item.CasID
+	}
+	return call, err
+}
+
+func (c *interceptedClient) FlushAll() error {
+	return c.FlushAllContext(context.Background())
+}
+
+func (c *interceptedClient) Get(key string) (*item.Item, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *interceptedClient) Touch(key string, seconds int32) (err error) {
+	return c.TouchContext(context.Background(), key, seconds)
+}
+
+func (c *interceptedClient) GetMulti(keys []string) (map[string]*item.Item, error) {
+	return c.GetMultiContext(context.Background(), keys)
+}
+
+func (c *interceptedClient) Set(item *item.Item) error {
+	return c.SetContext(context.Background(), item)
+}
+
+func (c *interceptedClient) Add(item *item.Item) error {
+	return c.AddContext(context.Background(), item)
+}
+
+func (c *interceptedClient) Replace(item *item.Item) error {
+	return c.ReplaceContext(context.Background(), item)
+}
+
+func (c *interceptedClient) CompareAndSwap(item *item.Item) error {
+	return c.CompareAndSwapContext(context.Background(), item)
+}
+
+func (c *interceptedClient) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+func (c *interceptedClient) DeleteAll() error {
+	return c.DeleteAllContext(context.Background())
+}
+
+func (c *interceptedClient) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *interceptedClient) Increment(key string, delta uint64) (newValue uint64, err error) {
+	return c.IncrementContext(context.Background(), key, delta)
+}
+
+func (c *interceptedClient) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	return c.DecrementContext(context.Background(), key, delta)
+}
+
+func (c *interceptedClient) Exists(key string) (bool, error) {
+	return c.ExistsContext(context.Background(), key)
+}
+
+func (c *interceptedClient) FlushAllContext(ctx context.Context) error {
+	return c.intercept(ctx, &Call{Operation: "FlushAll"}, func(ctx context.Context, call *Call) error {
+		return c.next.FlushAllContext(ctx)
+	})
+}
+
+func (c *interceptedClient) GetContext(ctx context.Context, key string) (*item.Item, error) {
+	call := &Call{Operation: "Get", Keys: []string{key}}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Item, err = c.next.GetContext(ctx, call.Keys[0])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return call.Item, nil
+}
+
+func (c *interceptedClient) TouchContext(ctx context.Context, key string, seconds int32) (err error) {
+	return c.intercept(ctx, &Call{Operation: "Touch", Keys: []string{key}, Seconds: seconds}, func(ctx context.Context, call *Call) error {
+		return c.next.TouchContext(ctx, call.Keys[0], call.Seconds)
+	})
+}
+
+func (c *interceptedClient) GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	// Copy the keys so interceptors changing them don't change the caller's.
+	call := &Call{Operation: "GetMulti", Keys: append([]string(nil), keys...)}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Items, err = c.next.GetMultiContext(ctx, call.Keys)
+		return err
+	})
+	return call.Items, err
+}
+
+func (c *interceptedClient) SetContext(ctx context.Context, item *item.Item) error {
+	_, err := c.interceptItem(ctx, "Set", item, func(ctx context.Context, call *Call) error {
+		return c.next.SetContext(ctx, call.Item)
+	})
+	return err
+}
+
+func (c *interceptedClient) AddContext(ctx context.Context, item *item.Item) error {
+	_, err := c.interceptItem(ctx, "Add", item, func(ctx context.Context, call *Call) error {
+		return c.next.AddContext(ctx, call.Item)
+	})
+	return err
+}
+
+func (c *interceptedClient) ReplaceContext(ctx context.Context, item *item.Item) error {
+	_, err := c.interceptItem(ctx, "Replace", item, func(ctx context.Context, call *Call) error {
+		return c.next.ReplaceContext(ctx, call.Item)
+	})
+	return err
+}
+
+func (c *interceptedClient) CompareAndSwapContext(ctx context.Context, item *item.Item) error {
+	_, err := c.interceptItem(ctx, "CompareAndSwap", item, func(ctx context.Context, call *Call) error {
+		return c.next.CompareAndSwapContext(ctx, call.Item)
+	})
+	return err
+}
+
+func (c *interceptedClient) DeleteContext(ctx context.Context, key string) error {
+	return c.intercept(ctx, &Call{Operation: "Delete", Keys: []string{key}}, func(ctx context.Context, call *Call) error {
+		return c.next.DeleteContext(ctx, call.Keys[0])
+	})
+}
+
+func (c *interceptedClient) DeleteAllContext(ctx context.Context) error {
+	return c.intercept(ctx, &Call{Operation: "DeleteAll"}, func(ctx context.Context, call *Call) error {
+		return c.next.DeleteAllContext(ctx)
+	})
+}
+
+func (c *interceptedClient) PingContext(ctx context.Context) error {
+	return c.intercept(ctx, &Call{Operation: "Ping"}, func(ctx context.Context, call *Call) error {
+		return c.next.PingContext(ctx)
+	})
+}
+
+func (c *interceptedClient) IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	call := &Call{Operation: "Increment", Keys: []string{key}}
+	err = c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.NewValue, err = c.next.IncrementContext(ctx, call.Keys[0], delta)
+		return err
+	})
+	return call.NewValue, err
+}
+
+func (c *interceptedClient) DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	call := &Call{Operation: "Decrement", Keys: []string{key}}
+	err = c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.NewValue, err = c.next.DecrementContext(ctx, call.Keys[0], delta)
+		return err
+	})
+	return call.NewValue, err
+}
+
+func (c *interceptedClient) ExistsContext(ctx context.Context, key string) (bool, error) {
+	call := &Call{Operation: "Exists", Keys: []string{key}}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Exists, err = c.next.ExistsContext(ctx, call.Keys[0])
+		return err
+	})
+	return call.Exists, err
+}
+
+// GetOrLoad gets and stores the value with the Get and Set of the
+// intercepted client, so that the interceptors transform and observe
+// them like any other value. The keys of the call aren't used by the
+// operation, which runs Get and Set with key.
+func (c *interceptedClient) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	call := &Call{Operation: "GetOrLoad", Keys: []string{key}}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Value, err = getOrLoad(ctx, c, &c.loadGroup, c.negativeTTL, key, ttl, loader)
+		return err
+	})
+	return call.Value, err
+}
+
+func (c *interceptedClient) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	call := &Call{Operation: "MetaGet", Keys: []string{key}}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Item, call.Meta, err = c.next.MetaGet(ctx, call.Keys[0], opts)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return call.Item, call.Meta, nil
+}
+
+func (c *interceptedClient) MetaSet(ctx context.Context, it *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	call, err := c.interceptItem(ctx, "MetaSet", it, func(ctx context.Context, call *Call) (err error) {
+		call.Meta, err = c.next.MetaSet(ctx, call.Item, opts)
+		return err
+	})
+	return call.Meta, err
+}
+
+func (c *interceptedClient) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	call := &Call{Operation: "MetaDelete", Keys: []string{key}}
+	err := c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.Meta, err = c.next.MetaDelete(ctx, call.Keys[0], opts)
+		return err
+	})
+	return call.Meta, err
+}
+
+func (c *interceptedClient) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error) {
+	call := &Call{Operation: "MetaArithmetic", Keys: []string{key}}
+	err = c.intercept(ctx, call, func(ctx context.Context, call *Call) (err error) {
+		call.NewValue, call.Meta, err = c.next.MetaArithmetic(ctx, call.Keys[0], opts)
+		return err
+	})
+	return call.NewValue, call.Meta, err
+}
+
+func (c *interceptedClient) MetaNoop(ctx context.Context) error {
+	return c.intercept(ctx, &Call{Operation: "MetaNoop"}, func(ctx context.Context, call *Call) error {
+		return c.next.MetaNoop(ctx)
+	})
+}
@@ -0,0 +1,114 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestIntercept(t *testing.T) {
+
+	t.Run("Order", func(t *testing.T) {
+		var calls []string
+		record := func(name string) Interceptor {
+			return func(ctx context.Context, call *Call, invoker Invoker) error {
+				calls = append(calls, name+">"+call.Operation)
+				err := invoker(ctx, call)
+				calls = append(calls, name+"<"+call.Operation)
+				return err
+			}
+		}
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithInterceptors(record("outer"), record("inner")).
+			Build()
+
+		client.GetMulti([]string{"a", "b"})
+		expected := []string{"outer>GetMulti", "inner>GetMulti", "inner<GetMulti", "outer<GetMulti"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Errorf("Expected calls to be %v, got %v", expected, calls)
+		}
+	})
+
+	t.Run("Results", func(t *testing.T) {
+		var seen *Call
+		client := Intercept(memcachemock.NewFakeClient(), func(ctx context.Context, call *Call, invoker Invoker) error {
+			err := invoker(ctx, call)
+			seen = call
+			return err
+		})
+
+		client.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+		if _, err := client.Get("foo"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if seen.Operation != "Get" || string(seen.Item.Value) != "bar" {
+			t.Errorf("Expected the call to hold the item found, got %+v", seen)
+		}
+
+		client.Set(&item.Item{Key: "counter", Value: []byte("1")})
+		client.Increment("counter", 2)
+		if seen.Operation != "Increment" || seen.NewValue != 3 {
+			t.Errorf("Expected the call to hold the new value, got %+v", seen)
+		}
+	})
+
+	t.Run("RewriteKeys", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := Intercept(fake, func(ctx context.Context, call *Call, invoker Invoker) error {
+			for i := range call.Keys {
+				call.Keys[i] = "app:" + call.Keys[i]
+			}
+			if call.Item != nil {
+				it := *call.Item
+				it.Key = "app:" + it.Key
+				call.Item = &it
+			}
+			return invoker(ctx, call)
+		})
+
+		it := &item.Item{Key: "foo", Value: []byte("bar")}
+		if err := client.Set(it); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "foo" || it.CasID == 0 {
+			t.Errorf("Expected item key to be kept and CAS ID to be updated, got %+v", it)
+		}
+		if _, err := fake.Get("app:foo"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if exists, err := client.Exists("foo"); err != nil || !exists {
+			t.Errorf("Expected key to exist, got %v (%v)", exists, err)
+		}
+	})
+
+	t.Run("ShortCircuit", func(t *testing.T) {
+		errDenied := errors.New("denied")
+		fake := memcachemock.NewFakeClient()
+		client := Intercept(fake, func(ctx context.Context, call *Call, invoker Invoker) error {
+			if call.Operation == "FlushAll" {
+				return errDenied
+			}
+			return invoker(ctx, call)
+		})
+
+		client.Set(&item.Item{Key: "foo"})
+		if err := client.FlushAll(); !errors.Is(err, errDenied) {
+			t.Errorf("Expected error to be %v, got %v", errDenied, err)
+		}
+		if fake.Len() != 1 {
+			t.Errorf("Expected %v items, got %v", 1, fake.Len())
+		}
+	})
+
+	t.Run("NoInterceptors", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		if client := Intercept(fake); client != Client(fake) {
+			t.Errorf("Expected client not to be wrapped")
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package memcache
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ketamaPointsPerServer is the number of points a server gets on the ring
+// when all servers have the same weight. It matches libmemcached and
+// twemproxy.
+const ketamaPointsPerServer = 160
+
+// ketamaDefaultPort is the port libmemcached leaves out of the names of
+// the servers when hashing their points.
+const ketamaDefaultPort = "11211"
+
+// ErrServerWeights is returned by the operations of a client built with
+// server weights but without UseKetama, which would ignore them.
+var ErrServerWeights = errors.New("memcache: server weights require a ketama ring")
+
+type ketamaPoint struct {
+	hash uint32
+	addr net.Addr
+}
+
+// ketamaServerList is a memcache.ServerSelector that distributes keys over
+// the servers with a ketama consistent hashing ring, so adding or removing
+// a server only remaps the keys that belonged to it. Points are derived from
+// the server names as given, not from their resolved addresses, and without
+// the default port like libmemcached does, which keeps the distribution
+// compatible with it.
+type ketamaServerList struct {
+	mu     sync.RWMutex
+	addrs  []net.Addr
+	points []ketamaPoint
+	err    error
+}
+
+// setServers changes the set of servers on the ring. Each server gets the
+// weight found in weights, or 1 if there is none. A server listed multiple
+// times gets a proportional amount of weight. If any of the server names
+// fail to resolve, the error is returned by every later PickServer call.
+func (ks *ketamaServerList) setServers(servers []string, weights map[string]int) error {
+	var (
+		names       []string
+		serverAddrs = make(map[string]net.Addr)
+		serverTotal = make(map[string]int)
+		totalWeight int
+	)
+	for _, server := range servers {
+		weight, ok := weights[server]
+		if !ok {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		if _, ok := serverAddrs[server]; !ok {
+			addr, err := resolveServer(server)
+			if err != nil {
+				ks.mu.Lock()
+				defer ks.mu.Unlock()
+				ks.err = err
+				return err
+			}
+			serverAddrs[server] = addr
+			names = append(names, server)
+		}
+		serverTotal[server] += weight
+		totalWeight += weight
+	}
+
+	var (
+		addrs  = make([]net.Addr, 0, len(names))
+		points []ketamaPoint
+	)
+	for _, name := range names {
+		addrs = append(addrs, serverAddrs[name])
+
+		pct := float64(serverTotal[name]) / float64(totalWeight)
+		hashes := int(math.Floor(pct*ketamaPointsPerServer/4*float64(len(names)) + 0.0000000001))
+		host := ketamaHostname(name)
+		for i := 0; i < hashes; i++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", host, i)))
+			for x := 0; x < 4; x++ {
+				points = append(points, ketamaPoint{
+					hash: ketamaHash(digest, x),
+					addr: serverAddrs[name],
+				})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.addrs = addrs
+	ks.points = points
+	ks.err = nil
+	return nil
+}
+
+// PickServer returns the server owning the first point on the ring at or
+// after the hash of key.
+func (ks *ketamaServerList) PickServer(key string) (net.Addr, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.err != nil {
+		return nil, ks.err
+	}
+	if len(ks.points) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+
+	hash := ketamaHash(md5.Sum([]byte(key)), 0)
+	i := sort.Search(len(ks.points), func(i int) bool {
+		return ks.points[i].hash >= hash
+	})
+	if i == len(ks.points) {
+		i = 0
+	}
+	return ks.points[i].addr, nil
+}
+
+// Each iterates over each server calling the given function.
+func (ks *ketamaServerList) Each(f func(net.Addr) error) error {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.err != nil {
+		return ks.err
+	}
+	for _, a := range ks.addrs {
+		if err := f(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ketamaHostname returns the name of server hashed for its points, the
+// server without its port if it is the default one, like libmemcached.
+func ketamaHostname(server string) string {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || port != ketamaDefaultPort {
+		return server
+	}
+	return host
+}
+
+// ketamaHash returns the x-th little-endian uint32 of an md5 digest.
+func ketamaHash(digest [md5.Size]byte, x int) uint32 {
+	return uint32(digest[3+x*4])<<24 |
+		uint32(digest[2+x*4])<<16 |
+		uint32(digest[1+x*4])<<8 |
+		uint32(digest[x*4])
+}
+
+// failedServerList is a memcache.ServerSelector whose servers couldn't be
+// set. Every call returns the error.
+type failedServerList struct {
+	err error
+}
+
+func (s failedServerList) PickServer(key string) (net.Addr, error) { return nil, s.err }
+func (s failedServerList) Each(f func(net.Addr) error) error       { return s.err }
+
+// staticAddr caches the Network() and String() values from any net.Addr.
+type staticAddr struct {
+	ntw, str string
+}
+
+func (s *staticAddr) Network() string { return s.ntw }
+func (s *staticAddr) String() string  { return s.str }
+
+// resolveServer resolves a server name the same way memcache.ServerList
+// does: names containing a slash are unix sockets, anything else is TCP.
+func resolveServer(server string) (net.Addr, error) {
+	var (
+		addr net.Addr
+		err  error
+	)
+	if strings.Contains(server, "/") {
+		addr, err = net.ResolveUnixAddr("unix", server)
+	} else {
+		addr, err = net.ResolveTCPAddr("tcp", server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &staticAddr{ntw: addr.Network(), str: addr.String()}, nil
+}
@@ -0,0 +1,175 @@
+package memcache
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestKetamaServerList(t *testing.T) {
+	servers := []string{
+		"127.0.0.1:11211",
+		"127.0.0.2:11211",
+		"127.0.0.3:11211",
+		"127.0.0.4:11211",
+	}
+
+	pickAll := func(ks *ketamaServerList, n int) map[string]string {
+		picked := make(map[string]string, n)
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			addr, err := ks.PickServer(key)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			picked[key] = addr.String()
+		}
+		return picked
+	}
+
+	t.Run("NoServers", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		if _, err := ks.PickServer("key"); !errors.Is(err, memcache.ErrNoServers) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrNoServers, err)
+		}
+	})
+
+	t.Run("InvalidServer", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		if err := ks.setServers([]string{"127.0.0.1:notaport"}, nil); err == nil {
+			t.Fatalf("Expected an error")
+		}
+		if _, err := ks.PickServer("key"); err == nil {
+			t.Errorf("Expected PickServer to return an error")
+		}
+	})
+
+	t.Run("Points", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		ks.setServers(servers, nil)
+		if len(ks.points) != ketamaPointsPerServer*len(servers) {
+			t.Errorf("Expected %v points, got %v", ketamaPointsPerServer*len(servers), len(ks.points))
+		}
+	})
+
+	t.Run("Each", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		ks.setServers(servers, nil)
+		var got []string
+		ks.Each(func(addr net.Addr) error {
+			got = append(got, addr.String())
+			return nil
+		})
+		if len(got) != len(servers) || got[0] != servers[0] {
+			t.Errorf("Expected servers to be %v, got %v", servers, got)
+		}
+	})
+
+	t.Run("RemoveServer", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		ks.setServers(servers, nil)
+		before := pickAll(ks, 10000)
+
+		ks.setServers(servers[:3], nil)
+		after := pickAll(ks, 10000)
+
+		for key, server := range before {
+			if server != servers[3] && after[key] != server {
+				t.Fatalf("Expected key %v to stay on %v, moved to %v", key, server, after[key])
+			}
+		}
+	})
+
+	t.Run("Weights", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		ks.setServers(servers[:2], map[string]int{servers[0]: 3})
+
+		counts := make(map[string]int)
+		for _, server := range pickAll(ks, 10000) {
+			counts[server]++
+		}
+		if counts[servers[0]] < counts[servers[1]]*2 {
+			t.Errorf("Expected %v to get about 3 times more keys than %v, got %v", servers[0], servers[1], counts)
+		}
+	})
+
+	t.Run("Hostname", func(t *testing.T) {
+		ks := new(ketamaServerList)
+		ks.setServers([]string{"127.0.0.1:11211", "127.0.0.3:11212"}, nil)
+
+		// libmemcached leaves the default port out of the hashed names.
+		want := map[uint32]bool{
+			ketamaHash(md5.Sum([]byte("127.0.0.1-0")), 0):       false,
+			ketamaHash(md5.Sum([]byte("127.0.0.3:11212-0")), 0): false,
+		}
+		for _, p := range ks.points {
+			if _, ok := want[p.hash]; ok {
+				want[p.hash] = true
+			}
+		}
+		for hash, found := range want {
+			if !found {
+				t.Errorf("Expected point %#x on the ring", hash)
+			}
+		}
+	})
+
+	t.Run("ReferenceVectors", func(t *testing.T) {
+		// The servers expected for the keys follow the continuum of
+		// libmemcached.
+		servers := []string{"127.0.0.1:11211", "127.0.0.2:11211", "127.0.0.3:11212"}
+		tests := []struct {
+			name    string
+			weights map[string]int
+			keys    [][2]string
+		}{
+			{
+				name: "Default",
+				keys: [][2]string{
+					{"foo", "127.0.0.1:11211"},
+					{"baz", "127.0.0.3:11212"},
+					{"user:1", "127.0.0.3:11212"},
+					{"user:2", "127.0.0.1:11211"},
+					{"key:3", "127.0.0.2:11211"},
+					{"key:5", "127.0.0.2:11211"},
+					{"key:7", "127.0.0.3:11212"},
+					{"key:8", "127.0.0.2:11211"},
+					{"key:10", "127.0.0.2:11211"},
+					{"ketama", "127.0.0.3:11212"},
+				},
+			},
+			{
+				name:    "Weighted",
+				weights: map[string]int{"127.0.0.3:11212": 3},
+				keys: [][2]string{
+					{"foo", "127.0.0.1:11211"},
+					{"bar", "127.0.0.3:11212"},
+					{"user:2", "127.0.0.3:11212"},
+					{"session:abc", "127.0.0.1:11211"},
+					{"memcached", "127.0.0.3:11212"},
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ks := new(ketamaServerList)
+				if err := ks.setServers(servers, tt.weights); err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				for _, k := range tt.keys {
+					addr, err := ks.PickServer(k[0])
+					if err != nil {
+						t.Fatalf("Expected no error, got %v", err)
+					}
+					if addr.String() != k[1] {
+						t.Errorf("Expected key %v on %v, got %v", k[0], k[1], addr)
+					}
+				}
+			})
+		}
+	})
+}
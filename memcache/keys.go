@@ -0,0 +1,102 @@
+package memcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// maxKeyLength is the maximum length of the keys accepted by the servers.
+const maxKeyLength = 250
+
+// KeyNormalizer returns the key stored in the servers for a key of the
+// caller. It must always return the same key for a given key.
+type KeyNormalizer func(key string) string
+
+// HashInvalidKeys is a KeyNormalizer keeping the keys accepted by the
+// servers, and replacing the others, longer than 250 bytes or holding
+// spaces or control characters, by their readable beginning followed by
+// "#" and their SHA-256 hash. Empty keys are kept, and still rejected.
+func HashInvalidKeys(key string) string {
+	if key == "" || legalKey(key) {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	suffix := "#" + hex.EncodeToString(sum[:])
+	readable := []byte(key)
+	if len(readable) > maxKeyLength-len(suffix) {
+		readable = readable[:maxKeyLength-len(suffix)]
+	}
+	for i, c := range readable {
+		if c <= ' ' || c == 0x7f {
+			readable[i] = '_'
+		}
+	}
+	return string(readable) + suffix
+}
+
+// newNormalizeInterceptor returns an interceptor storing the keys as
+// returned by normalize.
+func newNormalizeInterceptor(normalize KeyNormalizer) Interceptor {
+	return newKeyInterceptor(func(ctx context.Context, key string) (string, error) {
+		return normalize(key), nil
+	})
+}
+
+// keyMapper maps a key of the caller to the key stored in the servers.
+type keyMapper func(ctx context.Context, key string) (string, error)
+
+// newKeyInterceptor returns an interceptor storing the keys as mapped by
+// mapKey. The items it finds are given back with the keys of the caller.
+func newKeyInterceptor(mapKey keyMapper) Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		if len(call.Keys) == 0 {
+			return invoker(ctx, call)
+		}
+
+		keys := call.Keys
+		mapped := make([]string, len(keys))
+		original := make(map[string]string, len(keys))
+		for i, key := range keys {
+			k, err := mapKey(ctx, key)
+			if err != nil {
+				return err
+			}
+			mapped[i] = k
+			original[k] = key
+		}
+
+		call.Keys = mapped
+		if call.Item != nil {
+			call.Item = withKey(call.Item, mapped[0])
+		}
+		err := invoker(ctx, call)
+		call.Keys = keys
+
+		if call.Item != nil {
+			call.Item = withKey(call.Item, keys[0])
+		}
+		if call.Items != nil {
+			items := make(map[string]*item.Item, len(call.Items))
+			for k, it := range call.Items {
+				key, ok := original[k]
+				if !ok {
+					key = k
+				}
+				items[key] = withKey(it, key)
+			}
+			call.Items = items
+		}
+		return err
+	}
+}
+
+// withKey returns a copy of it with the key.
+func withKey(it *item.Item, key string) *item.Item {
+	cp := *it
+	cp.Key = key
+	return &cp
+}
@@ -0,0 +1,115 @@
+package memcache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestHashInvalidKeys(t *testing.T) {
+	long := strings.Repeat("a", 300)
+
+	tests := []struct {
+		name   string
+		key    string
+		prefix string
+		hashed bool
+	}{
+		{"Valid", "foo", "foo", false},
+		{"Empty", "", "", false},
+		{"MaxLength", long[:250], long[:250], false},
+		{"TooLong", long, long[:185], true},
+		{"Spaces", "https://example.com/a b", "https://example.com/a_b", true},
+		{"ControlCharacters", "foo\r\nbar\x7f", "foo__bar_", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := HashInvalidKeys(tt.key)
+			if !tt.hashed {
+				if key != tt.key {
+					t.Errorf("Expected key to be %q, got %q", tt.key, key)
+				}
+				return
+			}
+			if !legalKey(key) {
+				t.Errorf("Expected key %q to be legal", key)
+			}
+			if !strings.HasPrefix(key, tt.prefix+"#") {
+				t.Errorf("Expected key to start with %q, got %q", tt.prefix+"#", key)
+			}
+			if key != HashInvalidKeys(tt.key) {
+				t.Errorf("Expected key to be stable")
+			}
+		})
+	}
+
+	t.Run("Distinct", func(t *testing.T) {
+		if HashInvalidKeys(long+"b") == HashInvalidKeys(long+"c") {
+			t.Errorf("Expected keys with the same beginning to be distinct")
+		}
+	})
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	fake := memcachemock.NewFakeClient()
+	client := NewBuilder().
+		WithClient(fake).
+		WithKeyPrefix("ns:").
+		WithKeyNormalizer(HashInvalidKeys).
+		Build()
+
+	long := strings.Repeat("a", 300)
+	keys := []string{"https://example.com/a b", long, "foo"}
+
+	t.Run("Set", func(t *testing.T) {
+		for _, key := range keys {
+			it := &item.Item{Key: key, Value: []byte(key)}
+			if err := client.Set(it); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if it.Key != key {
+				t.Errorf("Expected key to be %v, got %v", key, it.Key)
+			}
+		}
+		if _, err := fake.Get(HashInvalidKeys("ns:" + long)); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, key := range keys {
+			it, err := client.Get(key)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if it.Key != key || string(it.Value) != key {
+				t.Errorf("Expected item key and value to be %v, got %v and %v", key, it.Key, string(it.Value))
+			}
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		items, err := client.GetMulti(append(keys, "missing key"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(items) != len(keys) {
+			t.Fatalf("Expected %v items, got %v", len(keys), len(items))
+		}
+		for _, key := range keys {
+			if it := items[key]; it == nil || it.Key != key {
+				t.Errorf("Expected item %v to be found with its key", key)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		for _, key := range keys {
+			if err := client.Delete(key); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}
+	})
+}
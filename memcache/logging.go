@@ -0,0 +1,78 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// KeyRedactor returns the form of a key written to the logs, for keys
+// holding data that must not be logged, such as user identifiers. It is
+// given the keys stored in the servers, with the prefix of WithKeyPrefix
+// and as returned by the normalizer of WithKeyNormalizer, not the keys of
+// the caller.
+type KeyRedactor func(key string) string
+
+// newLoggingInterceptor returns an interceptor logging the failed
+// operations at error level, and the operations lasting longer than slow,
+// if positive, at warn level. Cache misses aren't failures. selector, if
+// not nil, picks the server of the keys. With redact, the text of the
+// errors, which may hold keys, is left out and only their type is logged.
+func newLoggingInterceptor(logger *slog.Logger, slow time.Duration, redact KeyRedactor, selector memcache.ServerSelector) Interceptor {
+	errorText := redact == nil
+	if redact == nil {
+		redact = func(key string) string { return key }
+	}
+
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		start := time.Now()
+		err := invoker(ctx, call)
+		duration := time.Since(start)
+
+		failed := err != nil && !errors.Is(err, memcache.ErrCacheMiss)
+		level := slog.LevelError
+		msg := "memcache: operation failed"
+		if !failed {
+			if slow <= 0 || duration < slow {
+				return err
+			}
+			level = slog.LevelWarn
+			msg = "memcache: slow operation"
+		}
+		if !logger.Enabled(ctx, level) {
+			return err
+		}
+
+		attrs := []slog.Attr{
+			slog.String("operation", call.Operation),
+			slog.Duration("duration", duration),
+		}
+		switch len(call.Keys) {
+		case 0:
+		case 1:
+			attrs = append(attrs, slog.String("key", redact(call.Keys[0])))
+		default:
+			keys := make([]string, len(call.Keys))
+			for i, key := range call.Keys {
+				keys[i] = redact(key)
+			}
+			attrs = append(attrs, slog.Any("keys", keys))
+		}
+		if selector != nil && len(call.Keys) == 1 {
+			if addr, err := selector.PickServer(call.Keys[0]); err == nil {
+				attrs = append(attrs, slog.String("server", addr.String()))
+			}
+		}
+		if failed {
+			attrs = append(attrs, slog.String("error.type", errorType(err)))
+			if errorText {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+		}
+		logger.LogAttrs(ctx, level, msg, attrs...)
+		return err
+	}
+}
@@ -0,0 +1,182 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogging(t *testing.T) {
+
+	t.Run("FailedOperations", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithLogger(slog.New(slog.NewJSONHandler(buf, nil))).
+			WithKeyRedactor(func(key string) string { return "redacted" }).
+			Build()
+
+		client.Get("missing")
+		client.Add(&item.Item{Key: "user:42", Value: []byte("bar")})
+		client.Add(&item.Item{Key: "user:42", Value: []byte("bar")})
+
+		entries := logEntries(t, buf)
+		if len(entries) != 1 {
+			t.Fatalf("Expected %v log entry, got %v", 1, len(entries))
+		}
+		expected := map[string]interface{}{
+			"level":      "ERROR",
+			"operation":  "Add",
+			"key":        "redacted",
+			"error.type": "not_stored",
+		}
+		for k, v := range expected {
+			if entries[0][k] != v {
+				t.Errorf("Expected %v to be %v, got %v", k, v, entries[0][k])
+			}
+		}
+	})
+
+	t.Run("KeyInError", func(t *testing.T) {
+		server := memcachemock.NewMockServer(t)
+		server.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationGet,
+			Args:      memcachemock.Args{"ns:user:42"},
+
+			Error: fmt.Errorf("%w on key ns:user:42", memcache.ErrServerError),
+		})
+
+		buf := new(bytes.Buffer)
+		var redacted []string
+		client := NewBuilder().
+			WithClient(server.GetMockedClient()).
+			WithKeyPrefix("ns:").
+			WithLogger(slog.New(slog.NewJSONHandler(buf, nil))).
+			WithKeyRedactor(func(key string) string {
+				redacted = append(redacted, key)
+				return "redacted"
+			}).
+			Build()
+
+		client.Get("user:42")
+
+		if strings.Contains(buf.String(), "user:42") {
+			t.Errorf("Expected the key to be redacted from the logs, got %v", buf.String())
+		}
+		entries := logEntries(t, buf)
+		if len(entries) != 1 {
+			t.Fatalf("Expected %v log entry, got %v", 1, len(entries))
+		}
+		if _, ok := entries[0]["error"]; ok {
+			t.Errorf("Expected the error text to be left out, got %v", entries[0]["error"])
+		}
+		if entries[0]["error.type"] != "server_error" {
+			t.Errorf("Expected error.type to be %v, got %v", "server_error", entries[0]["error.type"])
+		}
+		if len(redacted) != 1 || redacted[0] != "ns:user:42" {
+			t.Errorf("Expected the redactor to be given the stored key %v, got %v", "ns:user:42", redacted)
+		}
+	})
+
+	t.Run("ErrorText", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithLogger(slog.New(slog.NewJSONHandler(buf, nil))).
+			Build()
+
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		entries := logEntries(t, buf)
+		if len(entries) != 1 {
+			t.Fatalf("Expected %v log entry, got %v", 1, len(entries))
+		}
+		if entries[0]["error"] == nil {
+			t.Errorf("Expected the error text to be logged without a redactor")
+		}
+	})
+
+	t.Run("SlowOperations", func(t *testing.T) {
+		server := memcachemock.NewMockServer(t)
+		server.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationDelete,
+			Args:      memcachemock.Args{"foo"},
+		})
+		server.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationDelete,
+			Args:      memcachemock.Args{"bar"},
+		})
+		server.InjectFault(memcachemock.Fault{
+			Operations: []memcachemock.Operation{memcachemock.OperationDelete},
+			Delay:      time.Millisecond * 20,
+		})
+
+		buf := new(bytes.Buffer)
+		client := NewBuilder().
+			WithClient(server.GetMockedClient()).
+			WithLogger(slog.New(slog.NewJSONHandler(buf, nil))).
+			SetSlowThreshold(time.Millisecond * 10).
+			Build()
+
+		if err := client.Delete("foo"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		server.ClearFaults()
+		if err := client.Delete("bar"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		entries := logEntries(t, buf)
+		if len(entries) != 1 {
+			t.Fatalf("Expected %v log entry, got %v", 1, len(entries))
+		}
+		if entries[0]["level"] != "WARN" {
+			t.Errorf("Expected level to be %v, got %v", "WARN", entries[0]["level"])
+		}
+		if entries[0]["key"] != "foo" {
+			t.Errorf("Expected key to be %v, got %v", "foo", entries[0]["key"])
+		}
+	})
+
+	t.Run("Server", func(t *testing.T) {
+		selector := (&clientBuilder{servers: []string{"127.0.0.1:1"}}).getServerSelector()
+		buf := new(bytes.Buffer)
+		client := Intercept(memcachemock.NewFakeClient(),
+			newLoggingInterceptor(slog.New(slog.NewJSONHandler(buf, nil)), 0, nil, selector))
+
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		entries := logEntries(t, buf)
+		if len(entries) != 1 {
+			t.Fatalf("Expected %v log entry, got %v", 1, len(entries))
+		}
+		if entries[0]["server"] != "127.0.0.1:1" {
+			t.Errorf("Expected server to be %v, got %v", "127.0.0.1:1", entries[0]["server"])
+		}
+	})
+}
@@ -0,0 +1,851 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// ErrProtocol is returned when a server sends a response the client
+// doesn't understand.
+var ErrProtocol = errors.New("memcache: unexpected response from server")
+
+// aLongTimeAgo is a deadline in the past used to abort blocked reads and
+// writes when a context is done.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Meta protocol status codes.
+const (
+	metaValue    = "VA"
+	metaHeader   = "HD"
+	metaMiss     = "EN"
+	metaNotFound = "NF"
+	metaNotStore = "NS"
+	metaExists   = "EX"
+	metaNoop     = "MN"
+)
+
+// metaTransport sends the operations with memcached's meta protocol
+// (mg, ms, md, ma and mn), managing its own pool of connections.
+type metaTransport struct {
+	selector     memcache.ServerSelector
+	timeout      time.Duration
+	maxIdleConns int
+	// tlsConfig, if not nil, wraps the connections in TLS.
+	tlsConfig *tls.Config
+
+	mu       sync.Mutex
+	freeconn map[string][]*metaConn
+	conns    connCounter
+}
+
+// metaConn is a connection to a server.
+type metaConn struct {
+	nc   net.Conn
+	rw   *bufio.ReadWriter
+	addr net.Addr
+}
+
+// metaResponse is a response line, with its value for VA responses.
+type metaResponse struct {
+	status string
+	flags  []string
+	value  []byte
+}
+
+func newMetaTransport(selector memcache.ServerSelector, timeout time.Duration, maxIdleConns int) *metaTransport {
+	return &metaTransport{
+		selector:     selector,
+		timeout:      timeout,
+		maxIdleConns: maxIdleConns,
+		freeconn:     make(map[string][]*metaConn),
+	}
+}
+
+func (t *metaTransport) FlushAll(ctx context.Context) error {
+	return t.selector.Each(func(addr net.Addr) error {
+		return t.withAddrConn(ctx, addr, func(cn *metaConn) error {
+			return cn.flushAll()
+		})
+	})
+}
+
+func (t *metaTransport) Get(ctx context.Context, key string) (*item.Item, error) {
+	it, _, err := t.MetaGet(ctx, key, nil)
+	return it, err
+}
+
+func (t *metaTransport) Touch(ctx context.Context, key string, seconds int32) error {
+	if !legalKey(key) {
+		return memcache.ErrMalformedKey
+	}
+	return t.withKeyConn(ctx, key, func(cn *metaConn) error {
+		resp, err := cn.roundTrip(fmt.Sprintf("mg %s T%d", key, seconds), nil, false, "")
+		if err != nil {
+			return err
+		}
+		return metaStatusError(resp.status)
+	})
+}
+
+func (t *metaTransport) GetMulti(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	keysByAddr := make(map[net.Addr][]string)
+	for _, key := range keys {
+		if !legalKey(key) {
+			return nil, memcache.ErrMalformedKey
+		}
+		addr, err := t.selector.PickServer(key)
+		if err != nil {
+			return nil, err
+		}
+		keysByAddr[addr] = append(keysByAddr[addr], key)
+	}
+
+	var (
+		mu    sync.Mutex
+		items = make(map[string]*item.Item)
+		errCh = make(chan error, len(keysByAddr))
+	)
+	for addr, keys := range keysByAddr {
+		go func(addr net.Addr, keys []string) {
+			errCh <- t.withAddrConn(ctx, addr, func(cn *metaConn) error {
+				return cn.getMulti(keys, func(it *item.Item) {
+					mu.Lock()
+					defer mu.Unlock()
+					items[it.Key] = it
+				})
+			})
+		}(addr, keys)
+	}
+
+	var err error
+	for range keysByAddr {
+		if ge := <-errCh; ge != nil {
+			err = ge
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (t *metaTransport) Set(ctx context.Context, it *item.Item) error {
+	_, err := t.set(ctx, it, &item.MetaSetOptions{Mode: item.MetaSetModeSet}, false)
+	return err
+}
+
+func (t *metaTransport) Add(ctx context.Context, it *item.Item) error {
+	_, err := t.set(ctx, it, &item.MetaSetOptions{Mode: item.MetaSetModeAdd}, false)
+	return err
+}
+
+func (t *metaTransport) Replace(ctx context.Context, it *item.Item) error {
+	_, err := t.set(ctx, it, &item.MetaSetOptions{Mode: item.MetaSetModeReplace}, false)
+	return err
+}
+
+func (t *metaTransport) CompareAndSwap(ctx context.Context, it *item.Item) error {
+	_, err := t.set(ctx, it, &item.MetaSetOptions{Mode: item.MetaSetModeSet, CompareCasID: it.CasID}, true)
+	return err
+}
+
+func (t *metaTransport) Delete(ctx context.Context, key string) error {
+	_, err := t.MetaDelete(ctx, key, nil)
+	return err
+}
+
+func (t *metaTransport) DeleteAll(ctx context.Context) error {
+	return t.FlushAll(ctx)
+}
+
+func (t *metaTransport) Ping(ctx context.Context) error {
+	return t.MetaNoop(ctx)
+}
+
+func (t *metaTransport) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	value, _, err := t.arithmetic(ctx, key, &item.MetaArithmeticOptions{Mode: item.MetaArithmeticIncrement}, delta)
+	return value, err
+}
+
+func (t *metaTransport) Decrement(ctx context.Context, key string, delta uint64) (uint64, error) {
+	value, _, err := t.arithmetic(ctx, key, &item.MetaArithmeticOptions{Mode: item.MetaArithmeticDecrement}, delta)
+	return value, err
+}
+
+func (t *metaTransport) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	if opts == nil {
+		opts = &item.MetaGetOptions{}
+	}
+	wireKey, err := metaKey(key, opts.Base64Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	flags := []string{"v", "f", "c", "t", "s", "l", "h"}
+	if opts.Base64Key {
+		flags = append(flags, "b")
+	}
+	if opts.Quiet {
+		flags = append(flags, "q")
+	}
+	if opts.NoLRUBump {
+		flags = append(flags, "u")
+	}
+	if opts.Opaque != "" {
+		flags = append(flags, "O"+opts.Opaque)
+	}
+	if opts.Touch {
+		flags = append(flags, fmt.Sprintf("T%d", opts.TouchTTL))
+	}
+	if opts.VivifyTTL != 0 {
+		flags = append(flags, fmt.Sprintf("N%d", opts.VivifyTTL))
+	}
+	if opts.RecacheTTL != 0 {
+		flags = append(flags, fmt.Sprintf("R%d", opts.RecacheTTL))
+	}
+
+	var (
+		it   *item.Item
+		meta *item.Meta
+	)
+	err = t.withKeyConn(ctx, key, func(cn *metaConn) error {
+		resp, err := cn.roundTrip(metaLine("mg", wireKey, flags), nil, opts.Quiet, metaMiss)
+		if err != nil {
+			return err
+		}
+		if resp.status != metaValue {
+			return metaStatusError(resp.status)
+		}
+		it = &item.Item{Key: key, Value: resp.value}
+		meta, err = parseMetaFlags(resp.flags, it)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return it, meta, nil
+}
+
+func (t *metaTransport) MetaSet(ctx context.Context, it *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	if opts == nil {
+		opts = &item.MetaSetOptions{}
+	}
+	return t.set(ctx, it, opts, opts.CompareCasID != 0)
+}
+
+// set stores it with a meta set. If compare is true, the item is only
+// stored if its CAS ID matches opts.CompareCasID, even when it is zero.
+func (t *metaTransport) set(ctx context.Context, it *item.Item, opts *item.MetaSetOptions, compare bool) (*item.Meta, error) {
+	wireKey, err := metaKey(it.Key, opts.Base64Key)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = item.MetaSetModeSet
+	}
+	flags := []string{
+		"c",
+		fmt.Sprintf("T%d", it.Expiration),
+		fmt.Sprintf("F%d", it.Flags),
+		fmt.Sprintf("M%c", mode),
+	}
+	if opts.Base64Key {
+		flags = append(flags, "b")
+	}
+	if opts.Quiet {
+		flags = append(flags, "q")
+	}
+	if opts.Opaque != "" {
+		flags = append(flags, "O"+opts.Opaque)
+	}
+	if compare {
+		flags = append(flags, fmt.Sprintf("C%d", opts.CompareCasID))
+	}
+	if opts.Invalidate {
+		flags = append(flags, "I")
+	}
+
+	var meta *item.Meta
+	err = t.withKeyConn(ctx, it.Key, func(cn *metaConn) error {
+		line := fmt.Sprintf("ms %s %d", wireKey, len(it.Value))
+		resp, err := cn.roundTrip(metaLine(line, "", flags), it.Value, opts.Quiet, metaHeader)
+		if err != nil {
+			return err
+		}
+		if err := metaStatusError(resp.status); err != nil {
+			return err
+		}
+		meta, err = parseMetaFlags(resp.flags, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if meta.CasID != 0 {
+		it.CasID = meta.CasID
+	}
+	return meta, nil
+}
+
+func (t *metaTransport) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	if opts == nil {
+		opts = &item.MetaDeleteOptions{}
+	}
+	wireKey, err := metaKey(key, opts.Base64Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []string
+	if opts.Base64Key {
+		flags = append(flags, "b")
+	}
+	if opts.Quiet {
+		flags = append(flags, "q")
+	}
+	if opts.Opaque != "" {
+		flags = append(flags, "O"+opts.Opaque)
+	}
+	if opts.CompareCasID != 0 {
+		flags = append(flags, fmt.Sprintf("C%d", opts.CompareCasID))
+	}
+	if opts.Invalidate {
+		flags = append(flags, "I")
+		if opts.InvalidateTTL != 0 {
+			flags = append(flags, fmt.Sprintf("T%d", opts.InvalidateTTL))
+		}
+	}
+
+	var meta *item.Meta
+	err = t.withKeyConn(ctx, key, func(cn *metaConn) error {
+		resp, err := cn.roundTrip(metaLine("md", wireKey, flags), nil, opts.Quiet, metaHeader)
+		if err != nil {
+			return err
+		}
+		if err := metaStatusError(resp.status); err != nil {
+			return err
+		}
+		meta, err = parseMetaFlags(resp.flags, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// MetaArithmetic increments or decrements the value by opts.Delta, or by
+// 1 if it is zero.
+func (t *metaTransport) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (uint64, *item.Meta, error) {
+	if opts == nil {
+		opts = &item.MetaArithmeticOptions{}
+	}
+	delta := opts.Delta
+	if delta == 0 {
+		delta = 1
+	}
+	return t.arithmetic(ctx, key, opts, delta)
+}
+
+// arithmetic increments or decrements the value by delta, which unlike
+// opts.Delta is sent as it is, so that Increment and Decrement by zero
+// leave the value unchanged like with the text protocol.
+func (t *metaTransport) arithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions, delta uint64) (uint64, *item.Meta, error) {
+	wireKey, err := metaKey(key, opts.Base64Key)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = item.MetaArithmeticIncrement
+	}
+	flags := []string{
+		"v", "c", "t",
+		fmt.Sprintf("M%c", mode),
+		fmt.Sprintf("D%d", delta),
+	}
+	if opts.Base64Key {
+		flags = append(flags, "b")
+	}
+	if opts.Quiet {
+		flags = append(flags, "q")
+	}
+	if opts.Opaque != "" {
+		flags = append(flags, "O"+opts.Opaque)
+	}
+	if opts.CompareCasID != 0 {
+		flags = append(flags, fmt.Sprintf("C%d", opts.CompareCasID))
+	}
+	if opts.VivifyTTL != 0 {
+		flags = append(flags, fmt.Sprintf("N%d", opts.VivifyTTL), fmt.Sprintf("J%d", opts.InitialValue))
+	}
+
+	var (
+		value uint64
+		meta  *item.Meta
+	)
+	err = t.withKeyConn(ctx, key, func(cn *metaConn) error {
+		resp, err := cn.roundTrip(metaLine("ma", wireKey, flags), nil, opts.Quiet, metaHeader)
+		if err != nil {
+			return err
+		}
+		if err := metaStatusError(resp.status); err != nil {
+			return err
+		}
+		if meta, err = parseMetaFlags(resp.flags, nil); err != nil {
+			return err
+		}
+		if resp.status != metaValue {
+			return nil
+		}
+		value, err = strconv.ParseUint(string(resp.value), 10, 64)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return value, meta, nil
+}
+
+func (t *metaTransport) MetaNoop(ctx context.Context) error {
+	return t.selector.Each(func(addr net.Addr) error {
+		return t.withAddrConn(ctx, addr, func(cn *metaConn) error {
+			resp, err := cn.roundTrip("mn", nil, false, "")
+			if err != nil {
+				return err
+			}
+			if resp.status != metaNoop {
+				return fmt.Errorf("%w: %s", ErrProtocol, resp.status)
+			}
+			return nil
+		})
+	})
+}
+
+func (t *metaTransport) withKeyConn(ctx context.Context, key string, fn func(*metaConn) error) error {
+	addr, err := t.selector.PickServer(key)
+	if err != nil {
+		return err
+	}
+	return t.withAddrConn(ctx, addr, fn)
+}
+
+// withAddrConn runs fn with a connection to addr. The connection goes back
+// to the pool unless fn fails with something other than a cache error.
+func (t *metaTransport) withAddrConn(ctx context.Context, addr net.Addr, fn func(*metaConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cn, err := t.getConn(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	stop := cn.watch(ctx, t.timeout)
+	err = fn(cn)
+	stop()
+
+	if err == nil || resumableError(err) {
+		t.putFreeConn(cn)
+	} else {
+		cn.nc.Close()
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The connection deadline may expire right before ctx is done.
+	if d, ok := ctx.Deadline(); ok && err != nil && !time.Now().Before(d) && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (t *metaTransport) getConn(ctx context.Context, addr net.Addr) (*metaConn, error) {
+	if cn, ok := t.getFreeConn(addr); ok {
+		return cn, nil
+	}
+	dialer := net.Dialer{Timeout: t.timeout}
+	dial := dialFunc(dialer.DialContext)
+	if t.tlsConfig != nil {
+		dial = tlsDialer(dial, t.tlsConfig)
+	}
+	// The timeout also bounds the TLS handshake.
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	nc, err := t.conns.dialer(dial)(ctx, addr.Network(), addr.String())
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil, &memcache.ConnectTimeoutError{Addr: addr}
+		}
+		return nil, err
+	}
+	return &metaConn{
+		nc:   nc,
+		rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+		addr: addr,
+	}, nil
+}
+
+func (t *metaTransport) poolStats() map[string]poolStats {
+	stats := t.conns.stats()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, s := range stats {
+		s.Idle = len(t.freeconn[addr])
+		stats[addr] = s
+	}
+	return stats
+}
+
+func (t *metaTransport) getFreeConn(addr net.Addr) (*metaConn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	freelist := t.freeconn[addr.String()]
+	if len(freelist) == 0 {
+		return nil, false
+	}
+	cn := freelist[len(freelist)-1]
+	t.freeconn[addr.String()] = freelist[:len(freelist)-1]
+	return cn, true
+}
+
+func (t *metaTransport) putFreeConn(cn *metaConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	freelist := t.freeconn[cn.addr.String()]
+	if len(freelist) >= t.maxIdleConns {
+		cn.nc.Close()
+		return
+	}
+	t.freeconn[cn.addr.String()] = append(freelist, cn)
+}
+
+// watch sets the connection deadline from timeout and the deadline of
+// ctx, and aborts any blocked read or write when ctx is done. The
+// returned function must be called once the connection is no longer used
+// for ctx.
+func (cn *metaConn) watch(ctx context.Context, timeout time.Duration) (stop func()) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.nc.SetDeadline(deadline)
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			cn.nc.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// roundTrip writes a request line, followed by its data block for ms
+// requests, and reads the response. The data block is written even when
+// data is empty, since the server waits for it. Quiet requests are
+// followed by a no-op so the end of the response is known; if the server
+// suppressed the response, a response with the suppressed status is
+// returned.
+func (cn *metaConn) roundTrip(line string, data []byte, quiet bool, suppressed string) (*metaResponse, error) {
+	if _, err := fmt.Fprintf(cn.rw, "%s\r\n", line); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(line, "ms ") {
+		if _, err := cn.rw.Write(data); err != nil {
+			return nil, err
+		}
+		if _, err := cn.rw.WriteString("\r\n"); err != nil {
+			return nil, err
+		}
+	}
+	if quiet {
+		if _, err := cn.rw.WriteString("mn\r\n"); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	resp, err := readMetaResponse(cn.rw.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if !quiet {
+		return resp, nil
+	}
+	if resp.status == metaNoop {
+		return &metaResponse{status: suppressed}, nil
+	}
+	end, err := readMetaResponse(cn.rw.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if end.status != metaNoop {
+		return nil, fmt.Errorf("%w: %s", ErrProtocol, end.status)
+	}
+	return resp, nil
+}
+
+// getMulti pipelines a quiet meta get for each key, so only hits are
+// answered, and reads the responses until the final no-op.
+func (cn *metaConn) getMulti(keys []string, cb func(*item.Item)) error {
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(cn.rw, "mg %s v f c k q\r\n", key); err != nil {
+			return err
+		}
+	}
+	if _, err := cn.rw.WriteString("mn\r\n"); err != nil {
+		return err
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := readMetaResponse(cn.rw.Reader)
+		if err != nil {
+			return err
+		}
+		switch resp.status {
+		case metaNoop:
+			return nil
+		case metaValue:
+			it := &item.Item{Value: resp.value}
+			if _, err := parseMetaFlags(resp.flags, it); err != nil {
+				return err
+			}
+			cb(it)
+		default:
+			return fmt.Errorf("%w: %s", ErrProtocol, resp.status)
+		}
+	}
+}
+
+func (cn *metaConn) flushAll() error {
+	if _, err := cn.rw.WriteString("flush_all\r\n"); err != nil {
+		return err
+	}
+	if err := cn.rw.Flush(); err != nil {
+		return err
+	}
+	line, err := cn.rw.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "OK\r\n" {
+		return responseError(line)
+	}
+	return nil
+}
+
+// readMetaResponse reads a meta response line and, for VA responses, the
+// value that follows it.
+func readMetaResponse(r *bufio.Reader) (*metaResponse, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrProtocol, line)
+	}
+
+	switch fields[0] {
+	case metaValue:
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w: %q", ErrProtocol, line)
+		}
+		size, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrProtocol, line)
+		}
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		if string(value[size:]) != "\r\n" {
+			return nil, fmt.Errorf("%w: corrupt value", ErrProtocol)
+		}
+		return &metaResponse{status: metaValue, flags: fields[2:], value: value[:size]}, nil
+	case metaHeader, metaMiss, metaNotFound, metaNotStore, metaExists, metaNoop:
+		return &metaResponse{status: fields[0], flags: fields[1:]}, nil
+	}
+	return nil, responseError(line)
+}
+
+// parseMetaFlags parses the flags of a response into its metadata. If it
+// is not nil, the item key, flags and CAS ID are filled in too.
+func parseMetaFlags(flags []string, it *item.Item) (*item.Meta, error) {
+	var (
+		meta   = &item.Meta{}
+		key    string
+		base64 bool
+	)
+	for _, flag := range flags {
+		if flag == "" {
+			continue
+		}
+		token := flag[1:]
+		var err error
+		switch flag[0] {
+		case 'b':
+			base64 = true
+		case 'c':
+			meta.CasID, err = strconv.ParseUint(token, 10, 64)
+		case 'f':
+			if it != nil {
+				var flags uint64
+				flags, err = strconv.ParseUint(token, 10, 32)
+				it.Flags = uint32(flags)
+			}
+		case 'h':
+			meta.Hit = token == "1"
+		case 'k':
+			key = token
+		case 'l':
+			meta.LastAccess, err = parseInt32(token)
+		case 'O':
+			meta.Opaque = token
+		case 's':
+			meta.Size, err = strconv.Atoi(token)
+		case 't':
+			meta.TTL, err = parseInt32(token)
+		case 'W':
+			meta.Won = true
+		case 'X':
+			meta.Stale = true
+		case 'Z':
+			meta.AlreadyWon = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: flag %q", ErrProtocol, flag)
+		}
+	}
+
+	if it != nil {
+		it.CasID = meta.CasID
+		if key != "" {
+			if base64 {
+				decoded, err := decodeMetaKey(key)
+				if err != nil {
+					return nil, err
+				}
+				key = decoded
+			}
+			it.Key = key
+		}
+	}
+	return meta, nil
+}
+
+// metaStatusError maps the meta status codes to the memcache errors.
+func metaStatusError(status string) error {
+	switch status {
+	case metaValue, metaHeader:
+		return nil
+	case metaMiss, metaNotFound:
+		return memcache.ErrCacheMiss
+	case metaNotStore:
+		return memcache.ErrNotStored
+	case metaExists:
+		return memcache.ErrCASConflict
+	}
+	return fmt.Errorf("%w: %s", ErrProtocol, status)
+}
+
+// responseError converts an error line sent by the server into an error.
+func responseError(line string) error {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "SERVER_ERROR"):
+		return fmt.Errorf("%w: %s", memcache.ErrServerError, strings.TrimSpace(strings.TrimPrefix(line, "SERVER_ERROR")))
+	case strings.HasPrefix(line, "CLIENT_ERROR"):
+		return fmt.Errorf("memcache: client error: %s", strings.TrimSpace(strings.TrimPrefix(line, "CLIENT_ERROR")))
+	}
+	return fmt.Errorf("%w: %q", ErrProtocol, line)
+}
+
+// metaKey returns the key as sent to the server.
+func metaKey(key string, base64Key bool) (string, error) {
+	if base64Key {
+		return base64.StdEncoding.EncodeToString([]byte(key)), nil
+	}
+	if !legalKey(key) {
+		return "", memcache.ErrMalformedKey
+	}
+	return key, nil
+}
+
+func decodeMetaKey(key string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: key %q", ErrProtocol, key)
+	}
+	return string(decoded), nil
+}
+
+func metaLine(cmd, key string, flags []string) string {
+	parts := []string{cmd}
+	if key != "" {
+		parts = append(parts, key)
+	}
+	return strings.Join(append(parts, flags...), " ")
+}
+
+func parseInt32(s string) (int32, error) {
+	v, err := strconv.ParseInt(s, 10, 32)
+	return int32(v), err
+}
+
+// resumableError reports whether err is only a protocol-level cache error,
+// after which the connection can be reused.
+func resumableError(err error) bool {
+	switch err {
+	case memcache.ErrCacheMiss, memcache.ErrCASConflict, memcache.ErrNotStored, memcache.ErrMalformedKey:
+		return true
+	}
+	return false
+}
+
+// legalKey reports whether key is accepted by the text and meta protocols.
+func legalKey(key string) bool {
+	if len(key) > maxKeyLength || len(key) == 0 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// newMetaTestTransport starts a server answering each request line with
+// the response returned by handler, and a meta transport talking to it.
+// The data block of ms requests is read and passed to handler too.
+func newMetaTestTransport(t *testing.T, handler func(line string, data []byte) string) *metaTransport {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(nc net.Conn) {
+				defer nc.Close()
+				r := bufio.NewReader(nc)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSuffix(line, "\r\n")
+					var data []byte
+					if fields := strings.Fields(line); len(fields) > 2 && fields[0] == "ms" {
+						size, _ := strconv.Atoi(fields[2])
+						data = make([]byte, size+2)
+						if _, err := io.ReadFull(r, data); err != nil {
+							return
+						}
+						data = data[:size]
+					}
+					if _, err := io.WriteString(nc, handler(line, data)); err != nil {
+						return
+					}
+				}
+			}(nc)
+		}
+	}()
+
+	ss := new(memcache.ServerList)
+	ss.SetServers(l.Addr().String())
+	return newMetaTransport(ss, time.Second, 2)
+}
+
+func TestMetaTransport(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("GetHit", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if line != "mg foo v f c t s l h" {
+				return "CLIENT_ERROR bad command line format\r\n"
+			}
+			return "VA 3 f5 c42 t-1 s3 l10 h1\r\nbar\r\n"
+		})
+
+		it, meta, err := tr.MetaGet(ctx, "foo", nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "foo" || string(it.Value) != "bar" || it.Flags != 5 || it.CasID != 42 {
+			t.Errorf("Expected item to be %v, got %v", "foo=bar", it)
+		}
+		if meta.TTL != -1 || meta.Size != 3 || meta.LastAccess != 10 || !meta.Hit {
+			t.Errorf("Unexpected meta %+v", meta)
+		}
+	})
+
+	t.Run("GetMiss", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			return "EN\r\n"
+		})
+
+		if _, err := tr.Get(ctx, "foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("QuietGetMiss", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if strings.HasPrefix(line, "mg ") {
+				return ""
+			}
+			return "MN\r\n"
+		})
+
+		_, _, err := tr.MetaGet(ctx, "foo", &item.MetaGetOptions{Quiet: true})
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Base64Key", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if line != "mg aGVsbG8gd29ybGQ= v f c t s l h b" {
+				return "CLIENT_ERROR bad command line format\r\n"
+			}
+			return "VA 1 b\r\nx\r\n"
+		})
+
+		it, _, err := tr.MetaGet(ctx, "hello world", &item.MetaGetOptions{Base64Key: true})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "hello world" || string(it.Value) != "x" {
+			t.Errorf("Expected item to be %v, got %v", "hello world=x", it)
+		}
+	})
+
+	t.Run("SetUpdatesCasID", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if line != "ms foo 3 c T10 F2 MS" || string(data) != "bar" {
+				return "CLIENT_ERROR bad command line format\r\n"
+			}
+			return "HD c7\r\n"
+		})
+
+		it := &item.Item{Key: "foo", Value: []byte("bar"), Flags: 2, Expiration: 10}
+		if err := tr.Set(ctx, it); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.CasID != 7 {
+			t.Errorf("Expected CasID to be %v, got %v", 7, it.CasID)
+		}
+	})
+
+	t.Run("CompareAndSwapConflict", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if !strings.HasSuffix(line, " C0") {
+				return "CLIENT_ERROR bad command line format\r\n"
+			}
+			return "EX\r\n"
+		})
+
+		err := tr.CompareAndSwap(ctx, &item.Item{Key: "foo", Value: []byte("bar")})
+		if !errors.Is(err, memcache.ErrCASConflict) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCASConflict, err)
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			switch line {
+			case "mg a v f c k q":
+				return "VA 1 f0 c1 ka\r\n1\r\n"
+			case "mg b v f c k q":
+				return ""
+			case "mn":
+				return "MN\r\n"
+			}
+			return "CLIENT_ERROR bad command line format\r\n"
+		})
+
+		items, err := tr.GetMulti(ctx, []string{"a", "b"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(items) != 1 || string(items["a"].Value) != "1" {
+			t.Errorf("Expected only a to be returned, got %v", items)
+		}
+	})
+
+	t.Run("Increment", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			if line != "ma foo v c t MI D5" {
+				return "CLIENT_ERROR bad command line format\r\n"
+			}
+			return "VA 2 c3 t-1\r\n15\r\n"
+		})
+
+		value, err := tr.Increment(ctx, "foo", 5)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if value != 15 {
+			t.Errorf("Expected value to be %v, got %v", 15, value)
+		}
+	})
+
+	t.Run("IncrementByZero", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			switch line {
+			case "ma foo v c t MI D0", "ma foo v c t MD D0":
+				return "VA 2 c3 t-1\r\n10\r\n"
+			}
+			return "CLIENT_ERROR bad command line format\r\n"
+		})
+
+		if value, err := tr.Increment(ctx, "foo", 0); err != nil || value != 10 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 10, value, err)
+		}
+		if value, err := tr.Decrement(ctx, "foo", 0); err != nil || value != 10 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 10, value, err)
+		}
+	})
+
+	t.Run("SetNilValue", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			switch line {
+			case "ms foo 0 c T0 F0 MS":
+				if len(data) != 0 {
+					return "CLIENT_ERROR bad data chunk\r\n"
+				}
+				return "HD c1\r\n"
+			case "mn":
+				return "MN\r\n"
+			}
+			return "CLIENT_ERROR bad command line format\r\n"
+		})
+
+		if err := tr.Set(ctx, &item.Item{Key: "foo"}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		// The connection is still in sync for the next request.
+		if err := tr.MetaNoop(ctx); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("MalformedKey", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			return "EN\r\n"
+		})
+
+		if _, err := tr.Get(ctx, "foo bar"); !errors.Is(err, memcache.ErrMalformedKey) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrMalformedKey, err)
+		}
+	})
+
+	t.Run("ContextDeadline", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			time.Sleep(time.Millisecond * 200)
+			return "EN\r\n"
+		})
+
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*20)
+		defer cancel()
+		if _, err := tr.Get(ctx, "foo"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected error to be %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+}
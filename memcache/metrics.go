@@ -0,0 +1,250 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "memcached_client"
+
+// Metrics is a prometheus.Collector exporting the metrics of the clients
+// built with ClientBuilder.WithMetrics:
+//
+//   - memcached_client_operations_total and
+//     memcached_client_operation_duration_seconds, by operation. GetOrLoad
+//     is counted as the Get and Set it runs, not as an operation of its
+//     own, so that its hits and misses aren't counted twice;
+//   - memcached_client_hits_total and memcached_client_misses_total, by
+//     operation, for Get, GetMulti and Exists;
+//   - memcached_client_errors_total, by server and error type. The server
+//     is only known for operations with one key;
+//   - memcached_client_pool_open_connections,
+//     memcached_client_pool_idle_connections,
+//     memcached_client_pool_dials_total and
+//     memcached_client_pool_dial_errors_total, by server, and
+//     memcached_client_pool_max_idle_connections. The idle connections are
+//     only known with the meta protocol: the servers of clients using the
+//     text protocol have no memcached_client_pool_idle_connections.
+//
+// The pools of the clients are collected until they are removed with
+// RemoveClient.
+type Metrics struct {
+	operations *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+	hits       *prometheus.CounterVec
+	misses     *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+
+	poolOpen       *prometheus.Desc
+	poolIdle       *prometheus.Desc
+	poolDials      *prometheus.Desc
+	poolDialErrors *prometheus.Desc
+	poolMaxIdle    *prometheus.Desc
+
+	mu    sync.Mutex
+	pools []metricsPool
+}
+
+// metricsPool is the connection pool of a client.
+type metricsPool struct {
+	transport    transport
+	maxIdleConns int
+}
+
+// NewMetrics creates the metrics of clients, to be registered with a
+// prometheus.Registerer.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Number of operations.",
+		}, []string{"operation"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+		}, []string{"operation"}),
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hits_total",
+			Help:      "Number of keys found.",
+		}, []string{"operation"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "misses_total",
+			Help:      "Number of keys not found.",
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of failed operations.",
+		}, []string{"server", "type"}),
+
+		poolOpen: prometheus.NewDesc(metricsNamespace+"_pool_open_connections",
+			"Number of open connections.", []string{"server"}, nil),
+		poolIdle: prometheus.NewDesc(metricsNamespace+"_pool_idle_connections",
+			"Number of idle connections.", []string{"server"}, nil),
+		poolDials: prometheus.NewDesc(metricsNamespace+"_pool_dials_total",
+			"Number of connections dialed.", []string{"server"}, nil),
+		poolDialErrors: prometheus.NewDesc(metricsNamespace+"_pool_dial_errors_total",
+			"Number of connections failed to dial.", []string{"server"}, nil),
+		poolMaxIdle: prometheus.NewDesc(metricsNamespace+"_pool_max_idle_connections",
+			"Maximum number of idle connections per server.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.durations.Describe(ch)
+	m.hits.Describe(ch)
+	m.misses.Describe(ch)
+	m.errors.Describe(ch)
+	ch <- m.poolOpen
+	ch <- m.poolIdle
+	ch <- m.poolDials
+	ch <- m.poolDialErrors
+	ch <- m.poolMaxIdle
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.durations.Collect(ch)
+	m.hits.Collect(ch)
+	m.misses.Collect(ch)
+	m.errors.Collect(ch)
+
+	m.mu.Lock()
+	pools := m.pools
+	m.mu.Unlock()
+
+	// Clients sharing the metrics add up.
+	stats := make(map[string]poolStats)
+	maxIdle := 0
+	for _, pool := range pools {
+		maxIdle += pool.maxIdleConns
+		for server, s := range pool.transport.poolStats() {
+			sum := stats[server]
+			sum.Open += s.Open
+			sum.Dials += s.Dials
+			sum.DialErrors += s.DialErrors
+			// The idle connections of a server are unknown if any of
+			// its pools doesn't know them.
+			if s.Idle < 0 || sum.Idle < 0 {
+				sum.Idle = -1
+			} else {
+				sum.Idle += s.Idle
+			}
+			stats[server] = sum
+		}
+	}
+	for server, s := range stats {
+		ch <- prometheus.MustNewConstMetric(m.poolOpen, prometheus.GaugeValue, float64(s.Open), server)
+		if s.Idle >= 0 {
+			ch <- prometheus.MustNewConstMetric(m.poolIdle, prometheus.GaugeValue, float64(s.Idle), server)
+		}
+		ch <- prometheus.MustNewConstMetric(m.poolDials, prometheus.CounterValue, float64(s.Dials), server)
+		ch <- prometheus.MustNewConstMetric(m.poolDialErrors, prometheus.CounterValue, float64(s.DialErrors), server)
+	}
+	if len(pools) > 0 {
+		ch <- prometheus.MustNewConstMetric(m.poolMaxIdle, prometheus.GaugeValue, float64(maxIdle))
+	}
+}
+
+// addPool adds the connection pool of a client to the metrics.
+func (m *Metrics) addPool(t transport, maxIdleConns int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pools = append(m.pools, metricsPool{transport: t, maxIdleConns: maxIdleConns})
+}
+
+// RemoveClient stops collecting the metrics of the connection pool of a
+// client built with the metrics, so that it can be garbage collected once
+// it is no longer used. The metrics of its operations are kept.
+func (m *Metrics) RemoveClient(cl Client) {
+	base := baseClient(cl)
+	if base == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pools := make([]metricsPool, 0, len(m.pools))
+	for _, pool := range m.pools {
+		if pool.transport != base.transport {
+			pools = append(pools, pool)
+		}
+	}
+	m.pools = pools
+}
+
+// baseClient returns the client built by ClientBuilder under the
+// interceptors of cl, or nil if there is none.
+func baseClient(cl Client) *client {
+	for {
+		switch c := cl.(type) {
+		case *client:
+			return c
+		case *interceptedClient:
+			cl = c.next
+		default:
+			return nil
+		}
+	}
+}
+
+// interceptor returns an interceptor recording the metrics of the
+// operations. selector, if not nil, picks the server of the keys.
+func (m *Metrics) interceptor(selector memcache.ServerSelector) Interceptor {
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		if call.Operation == "GetOrLoad" {
+			// Its Get and Set are intercepted on their own.
+			return invoker(ctx, call)
+		}
+		start := time.Now()
+		err := invoker(ctx, call)
+		m.operations.WithLabelValues(call.Operation).Inc()
+		m.durations.WithLabelValues(call.Operation).Observe(time.Since(start).Seconds())
+
+		switch call.Operation {
+		case "Get":
+			if err == nil {
+				m.hits.WithLabelValues(call.Operation).Inc()
+			} else if errors.Is(err, memcache.ErrCacheMiss) {
+				m.misses.WithLabelValues(call.Operation).Inc()
+			}
+		case "GetMulti":
+			if err == nil {
+				m.hits.WithLabelValues(call.Operation).Add(float64(len(call.Items)))
+				m.misses.WithLabelValues(call.Operation).Add(float64(len(call.Keys) - len(call.Items)))
+			}
+		case "Exists":
+			if err == nil && call.Exists {
+				m.hits.WithLabelValues(call.Operation).Inc()
+			} else if err == nil {
+				m.misses.WithLabelValues(call.Operation).Inc()
+			}
+		}
+
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			server := ""
+			if selector != nil && len(call.Keys) == 1 {
+				if addr, err := selector.PickServer(call.Keys[0]); err == nil {
+					server = addr.String()
+				}
+			}
+			m.errors.WithLabelValues(server, errorType(err)).Inc()
+		}
+		return err
+	}
+}
@@ -0,0 +1,215 @@
+package memcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+
+	t.Run("Operations", func(t *testing.T) {
+		metrics := NewMetrics()
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithMetrics(metrics).
+			Build()
+
+		client.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+		client.Get("foo")
+		client.Get("missing")
+		client.GetMulti([]string{"foo", "missing", "other"})
+		client.Exists("foo")
+		client.Exists("missing")
+
+		tests := []struct {
+			name     string
+			value    float64
+			expected float64
+		}{
+			{"operations Set", testutil.ToFloat64(metrics.operations.WithLabelValues("Set")), 1},
+			{"operations Get", testutil.ToFloat64(metrics.operations.WithLabelValues("Get")), 2},
+			{"hits Get", testutil.ToFloat64(metrics.hits.WithLabelValues("Get")), 1},
+			{"misses Get", testutil.ToFloat64(metrics.misses.WithLabelValues("Get")), 1},
+			{"hits GetMulti", testutil.ToFloat64(metrics.hits.WithLabelValues("GetMulti")), 1},
+			{"misses GetMulti", testutil.ToFloat64(metrics.misses.WithLabelValues("GetMulti")), 2},
+			{"hits Exists", testutil.ToFloat64(metrics.hits.WithLabelValues("Exists")), 1},
+			{"misses Exists", testutil.ToFloat64(metrics.misses.WithLabelValues("Exists")), 1},
+		}
+		for _, tt := range tests {
+			if tt.value != tt.expected {
+				t.Errorf("Expected %v to be %v, got %v", tt.name, tt.expected, tt.value)
+			}
+		}
+		if count := testutil.CollectAndCount(metrics, "memcached_client_errors_total"); count != 0 {
+			t.Errorf("Expected %v error series, got %v", 0, count)
+		}
+		if count := testutil.CollectAndCount(metrics, "memcached_client_operation_duration_seconds"); count != 4 {
+			t.Errorf("Expected %v duration series, got %v", 4, count)
+		}
+	})
+
+	t.Run("GetOrLoad", func(t *testing.T) {
+		metrics := NewMetrics()
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithMetrics(metrics).
+			Build()
+
+		loader := func() ([]byte, error) {
+			return []byte("bar"), nil
+		}
+		client.GetOrLoad(context.Background(), "foo", time.Minute, loader)
+		client.GetOrLoad(context.Background(), "foo", time.Minute, loader)
+
+		tests := []struct {
+			name     string
+			value    float64
+			expected float64
+		}{
+			{"operations GetOrLoad", testutil.ToFloat64(metrics.operations.WithLabelValues("GetOrLoad")), 0},
+			{"operations Get", testutil.ToFloat64(metrics.operations.WithLabelValues("Get")), 2},
+			{"operations Set", testutil.ToFloat64(metrics.operations.WithLabelValues("Set")), 1},
+			{"hits Get", testutil.ToFloat64(metrics.hits.WithLabelValues("Get")), 1},
+			{"misses Get", testutil.ToFloat64(metrics.misses.WithLabelValues("Get")), 1},
+		}
+		for _, tt := range tests {
+			if tt.value != tt.expected {
+				t.Errorf("Expected %v to be %v, got %v", tt.name, tt.expected, tt.value)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		metrics := NewMetrics()
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithMetrics(metrics).
+			Build()
+
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+		client.Add(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		if value := testutil.ToFloat64(metrics.errors.WithLabelValues("", "not_stored")); value != 1 {
+			t.Errorf("Expected not_stored errors to be %v, got %v", 1, value)
+		}
+	})
+
+	t.Run("Pool", func(t *testing.T) {
+		if memcachemock.MockupServer.IsEnabled() {
+			memcachemock.MockupServer.Stop()
+			defer memcachemock.MockupServer.Start()
+		}
+
+		metrics := NewMetrics()
+		client := NewBuilder().
+			WithServers("127.0.0.1:1").
+			SetMaxIdleConns(5).
+			WithMetrics(metrics).
+			Build()
+
+		if err := client.Ping(); err == nil {
+			t.Fatalf("Expected an error, got nil")
+		}
+
+		expected := `
+# HELP memcached_client_pool_dial_errors_total Number of connections failed to dial.
+# TYPE memcached_client_pool_dial_errors_total counter
+memcached_client_pool_dial_errors_total{server="127.0.0.1:1"} 1
+# HELP memcached_client_pool_max_idle_connections Maximum number of idle connections per server.
+# TYPE memcached_client_pool_max_idle_connections gauge
+memcached_client_pool_max_idle_connections 5
+`
+		err := testutil.CollectAndCompare(metrics, strings.NewReader(expected),
+			"memcached_client_pool_dial_errors_total", "memcached_client_pool_max_idle_connections")
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("IdleConnections", func(t *testing.T) {
+		tr := newMetaTestTransport(t, func(line string, data []byte) string {
+			return "MN\r\n"
+		})
+		metrics := NewMetrics()
+		metrics.addPool(tr, 2)
+
+		if err := tr.MetaNoop(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		stats := tr.poolStats()
+		if len(stats) != 1 {
+			t.Fatalf("Expected stats of %v server, got %v", 1, len(stats))
+		}
+		for server, s := range stats {
+			if s.Idle != 1 || s.Open != 1 || s.Dials != 1 {
+				t.Errorf("Expected 1 idle, open and dialed connection to %v, got %+v", server, s)
+			}
+		}
+		if count := testutil.CollectAndCount(metrics, "memcached_client_pool_idle_connections"); count != 1 {
+			t.Errorf("Expected %v idle connections series, got %v", 1, count)
+		}
+	})
+
+	t.Run("UnknownIdleConnections", func(t *testing.T) {
+		known := &statsTransport{stats: map[string]poolStats{"127.0.0.1:11211": {Idle: 2, Open: 2}}}
+		unknown := &statsTransport{stats: map[string]poolStats{"127.0.0.1:11211": {Idle: -1, Open: 1}}}
+
+		for _, pools := range [][]transport{{known, unknown}, {unknown, known}} {
+			metrics := NewMetrics()
+			for _, pool := range pools {
+				metrics.addPool(pool, 2)
+			}
+			if count := testutil.CollectAndCount(metrics, "memcached_client_pool_idle_connections"); count != 0 {
+				t.Errorf("Expected %v idle connections series, got %v", 0, count)
+			}
+			expected := `
+# HELP memcached_client_pool_open_connections Number of open connections.
+# TYPE memcached_client_pool_open_connections gauge
+memcached_client_pool_open_connections{server="127.0.0.1:11211"} 3
+`
+			err := testutil.CollectAndCompare(metrics, strings.NewReader(expected), "memcached_client_pool_open_connections")
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		}
+	})
+
+	t.Run("RemoveClient", func(t *testing.T) {
+		if memcachemock.MockupServer.IsEnabled() {
+			memcachemock.MockupServer.Stop()
+			defer memcachemock.MockupServer.Start()
+		}
+
+		metrics := NewMetrics()
+		client := NewBuilder().
+			WithServers("127.0.0.1:1").
+			WithMetrics(metrics).
+			WithKeyPrefix("ns:").
+			Build()
+		if count := testutil.CollectAndCount(metrics, "memcached_client_pool_max_idle_connections"); count != 1 {
+			t.Errorf("Expected %v max idle connections series, got %v", 1, count)
+		}
+
+		metrics.RemoveClient(client)
+		if count := testutil.CollectAndCount(metrics, "memcached_client_pool_max_idle_connections"); count != 0 {
+			t.Errorf("Expected %v max idle connections series, got %v", 0, count)
+		}
+	})
+}
+
+// statsTransport is a transport with fixed pool statistics.
+type statsTransport struct {
+	transport
+	stats map[string]poolStats
+}
+
+func (t *statsTransport) poolStats() map[string]poolStats {
+	return t.stats
+}
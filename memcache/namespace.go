@@ -0,0 +1,206 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// namespaceVersionPrefix prefixes the keys holding the versions of the
+// namespaces.
+const namespaceVersionPrefix = "namespace:"
+
+// ErrNamespaceVersion is returned when the version of a namespace stored
+// in the servers isn't a number.
+var ErrNamespaceVersion = errors.New("memcache: invalid namespace version")
+
+// Namespaced returns a client storing the keys of client with prefix, so
+// that services sharing the servers don't collide on keys. The items it
+// finds are given back without the prefix.
+//
+// FlushAll and DeleteAll still remove the keys of every namespace.
+func Namespaced(client Client, prefix string) Client {
+	return Intercept(client, newPrefixInterceptor(prefix))
+}
+
+// newPrefixInterceptor returns an interceptor storing the keys with
+// prefix.
+func newPrefixInterceptor(prefix string) Interceptor {
+	return newKeyInterceptor(func(ctx context.Context, key string) (string, error) {
+		return prefix + key, nil
+	})
+}
+
+// Namespaces are versioned namespaces of keys, which can be invalidated
+// at once without removing their keys.
+//
+// The keys of a namespace are stored with its name and version, kept in
+// the servers under the "namespace:" prefix. The versions are stored as
+// they are, without compression, chunking or encryption, so that the
+// servers can increment them. Invalidating a namespace increments its
+// version, so its previous keys become unreachable and are evicted by the
+// servers over time.
+//
+// By default the version is read from the servers on every operation,
+// which costs a round trip per operation but sees the invalidations of the
+// other clients at once. SetVersionTTL caches the versions instead.
+type Namespaces struct {
+	client     Client
+	versionTTL time.Duration
+
+	mu       sync.Mutex
+	versions map[string]namespaceVersion
+}
+
+// namespaceVersion is a version of a namespace cached until expiresAt.
+type namespaceVersion struct {
+	version   uint64
+	expiresAt time.Time
+}
+
+// NewNamespaces creates the versioned namespaces stored in client.
+func NewNamespaces(client Client) *Namespaces {
+	return &Namespaces{
+		client:   client,
+		versions: make(map[string]namespaceVersion),
+	}
+}
+
+// SetVersionTTL caches the versions of the namespaces for ttl, saving the
+// round trip reading them on every operation. The keys of a namespace
+// invalidated by another client stay reachable by this one until its
+// version expires; InvalidateNamespace drops the version cached by this
+// client at once. A ttl of zero, the default, doesn't cache them. It must
+// be called before the namespaces are used.
+func (n *Namespaces) SetVersionTTL(ttl time.Duration) *Namespaces {
+	n.versionTTL = ttl
+	return n
+}
+
+// Namespace returns a client storing its keys in the namespace name. The
+// items it finds are given back with the keys of the caller.
+//
+// FlushAll and DeleteAll only invalidate the namespace.
+func (n *Namespaces) Namespace(name string) Client {
+	return Intercept(n.client, func(ctx context.Context, call *Call, invoker Invoker) error {
+		switch call.Operation {
+		case "FlushAll", "DeleteAll":
+			return n.InvalidateNamespace(ctx, name)
+		}
+		if len(call.Keys) == 0 {
+			return invoker(ctx, call)
+		}
+
+		version, err := n.version(ctx, name)
+		if err != nil {
+			return err
+		}
+		prefix := name + ":" + strconv.FormatUint(version, 10) + ":"
+		return newPrefixInterceptor(prefix)(ctx, call, invoker)
+	})
+}
+
+// InvalidateNamespace makes the keys stored in the namespace name
+// unreachable.
+func (n *Namespaces) InvalidateNamespace(ctx context.Context, name string) error {
+	defer func() {
+		n.mu.Lock()
+		delete(n.versions, name)
+		n.mu.Unlock()
+	}()
+
+	ctx = withRawValues(ctx)
+	key := namespaceVersionPrefix + name
+	_, err := n.client.IncrementContext(ctx, key, 1)
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	// A namespace without a version has no reachable keys, but starts a
+	// new one in case another client is still using the evicted version.
+	err = n.client.AddContext(ctx, newNamespaceVersion(key))
+	if errors.Is(err, memcache.ErrNotStored) {
+		// Another client started it first.
+		_, err = n.client.IncrementContext(ctx, key, 1)
+	}
+	return err
+}
+
+// version returns the current version of the namespace name, starting
+// one if there is none, or the version cached for the version TTL.
+func (n *Namespaces) version(ctx context.Context, name string) (uint64, error) {
+	if n.versionTTL <= 0 {
+		return n.load(ctx, name)
+	}
+	n.mu.Lock()
+	cached, ok := n.versions[name]
+	n.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.version, nil
+	}
+
+	version, err := n.load(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	n.mu.Lock()
+	n.versions[name] = namespaceVersion{version: version, expiresAt: time.Now().Add(n.versionTTL)}
+	n.mu.Unlock()
+	return version, nil
+}
+
+// load reads the current version of the namespace name from the servers,
+// starting one if there is none.
+func (n *Namespaces) load(ctx context.Context, name string) (uint64, error) {
+	ctx = withRawValues(ctx)
+	key := namespaceVersionPrefix + name
+	it, err := n.client.GetContext(ctx, key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return n.init(ctx, key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseNamespaceVersion(it.Value)
+}
+
+// init starts the version stored at key.
+func (n *Namespaces) init(ctx context.Context, key string) (uint64, error) {
+	it := newNamespaceVersion(key)
+	err := n.client.AddContext(ctx, it)
+	if errors.Is(err, memcache.ErrNotStored) {
+		// Another client started it first.
+		it, err := n.client.GetContext(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		return parseNamespaceVersion(it.Value)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return parseNamespaceVersion(it.Value)
+}
+
+// newNamespaceVersion returns the item starting the version stored at
+// key. The version is derived from the time so that the keys of a version
+// evicted from the servers don't become reachable again.
+func newNamespaceVersion(key string) *item.Item {
+	return &item.Item{
+		Key:   key,
+		Value: []byte(strconv.FormatUint(uint64(time.Now().UnixNano()), 10)),
+	}
+}
+
+func parseNamespaceVersion(value []byte) (uint64, error) {
+	version, err := strconv.ParseUint(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil {
+		return 0, ErrNamespaceVersion
+	}
+	return version, nil
+}
@@ -0,0 +1,230 @@
+package memcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestNamespaced(t *testing.T) {
+
+	t.Run("Isolation", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		a := Namespaced(fake, "a:")
+		b := NewBuilder().WithClient(fake).WithKeyPrefix("b:").Build()
+
+		a.Set(&item.Item{Key: "foo", Value: []byte("A")})
+		b.Set(&item.Item{Key: "foo", Value: []byte("B")})
+
+		it, err := a.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "foo" || string(it.Value) != "A" {
+			t.Errorf("Expected item to be %v=%v, got %v=%v", "foo", "A", it.Key, string(it.Value))
+		}
+		it, err = fake.Get("b:foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "B" {
+			t.Errorf("Expected value to be %v, got %v", "B", string(it.Value))
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		client := Namespaced(fake, "ns:")
+		fake.Set(&item.Item{Key: "foo", Value: []byte("other")})
+		client.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+		client.Set(&item.Item{Key: "baz", Value: []byte("qux")})
+
+		items, err := client.GetMulti([]string{"foo", "baz", "missing"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("Expected %v items, got %v", 2, len(items))
+		}
+		for key, it := range items {
+			if it.Key != key {
+				t.Errorf("Expected item key to be %v, got %v", key, it.Key)
+			}
+		}
+		if string(items["foo"].Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v", "bar", string(items["foo"].Value))
+		}
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		client := Namespaced(memcachemock.NewFakeClient(), "ns:")
+		it := &item.Item{Key: "foo", Value: []byte("bar")}
+		client.Set(it)
+		if it.Key != "foo" {
+			t.Errorf("Expected key to be %v, got %v", "foo", it.Key)
+		}
+
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it.Value = []byte("baz")
+		if err := client.CompareAndSwap(it); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("InvalidateNamespace", func(t *testing.T) {
+		namespaces := NewNamespaces(memcachemock.NewFakeClient())
+		tenant42 := namespaces.Namespace("tenant:42")
+		tenant43 := namespaces.Namespace("tenant:43")
+
+		tenant42.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+		tenant43.Set(&item.Item{Key: "foo", Value: []byte("baz")})
+
+		it, err := tenant42.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if it.Key != "foo" || string(it.Value) != "bar" {
+			t.Errorf("Expected item to be %v=%v, got %v=%v", "foo", "bar", it.Key, string(it.Value))
+		}
+
+		if err := namespaces.InvalidateNamespace(ctx, "tenant:42"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant42.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if _, err := tenant43.Get("foo"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("DeleteAll", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.Set(&item.Item{Key: "other", Value: []byte("bar")})
+		tenant := NewNamespaces(fake).Namespace("tenant:42")
+		tenant.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		if err := tenant.DeleteAll(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if _, err := fake.Get("other"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("InvalidateEvictedNamespace", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		namespaces := NewNamespaces(fake)
+		tenant := namespaces.Namespace("tenant:42")
+		tenant.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		fake.Delete("namespace:tenant:42")
+		if err := namespaces.InvalidateNamespace(ctx, "tenant:42"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("TransformedValues", func(t *testing.T) {
+		encryption, err := NewEncryption(EncryptionKey{ID: 1, Key: bytes.Repeat([]byte{1}, 32)})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		fake := memcachemock.NewFakeClient()
+		client := NewBuilder().
+			WithClient(fake).
+			WithEncryption(encryption).
+			WithCompression(CompressionGzip, 0).
+			WithChunking(4).
+			Build()
+		namespaces := NewNamespaces(client)
+		tenant := namespaces.Namespace("tenant:42")
+
+		if err := tenant.Set(&item.Item{Key: "foo", Value: []byte("bar")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := tenant.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v", "bar", string(it.Value))
+		}
+
+		version, err := fake.Get("namespace:tenant:42")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := parseNamespaceVersion(version.Value); err != nil || version.Flags != 0 {
+			t.Errorf("Expected version to be stored as it is, got %q with flags %#x", version.Value, version.Flags)
+		}
+
+		if err := namespaces.InvalidateNamespace(ctx, "tenant:42"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("InvalidVersion", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		fake.Set(&item.Item{Key: "namespace:tenant:42", Value: []byte("nan")})
+
+		_, err := NewNamespaces(fake).Namespace("tenant:42").Get("foo")
+		if !errors.Is(err, ErrNamespaceVersion) {
+			t.Errorf("Expected error to be %v, got %v", ErrNamespaceVersion, err)
+		}
+	})
+
+	t.Run("VersionTTL", func(t *testing.T) {
+		fake := memcachemock.NewFakeClient()
+		namespaces := NewNamespaces(fake).SetVersionTTL(100 * time.Millisecond)
+		tenant := namespaces.Namespace("tenant:42")
+		tenant.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		// Another client invalidates the namespace.
+		if err := NewNamespaces(fake).InvalidateNamespace(ctx, "tenant:42"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant.Get("foo"); err != nil {
+			t.Errorf("Expected cached version to be used, got %v", err)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if _, err := tenant.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("InvalidateCachedVersion", func(t *testing.T) {
+		namespaces := NewNamespaces(memcachemock.NewFakeClient()).SetVersionTTL(time.Minute)
+		tenant := namespaces.Namespace("tenant:42")
+		tenant.Set(&item.Item{Key: "foo", Value: []byte("bar")})
+
+		if err := namespaces.InvalidateNamespace(ctx, "tenant:42"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := tenant.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package memcache
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// poolStats are the statistics of the connections of a transport to a
+// server.
+type poolStats struct {
+	// Idle is the number of idle connections, -1 if unknown.
+	Idle       int
+	Open       int
+	Dials      uint64
+	DialErrors uint64
+}
+
+// dialFunc connects to the address on the named network.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// connCounter counts the connections a transport dials to each server.
+type connCounter struct {
+	mu      sync.Mutex
+	servers map[string]*poolStats
+}
+
+// dialer returns a dial func counting the connections dialed by dial.
+func (c *connCounter) dialer(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		nc, err := dial(ctx, network, address)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		stats := c.server(address)
+		stats.Dials++
+		if err != nil {
+			stats.DialErrors++
+			return nil, err
+		}
+		stats.Open++
+		return &countedConn{Conn: nc, counter: c, address: address}, nil
+	}
+}
+
+// server returns the statistics of a server. c.mu must be held.
+func (c *connCounter) server(address string) *poolStats {
+	if c.servers == nil {
+		c.servers = make(map[string]*poolStats)
+	}
+	stats, ok := c.servers[address]
+	if !ok {
+		stats = &poolStats{Idle: -1}
+		c.servers[address] = stats
+	}
+	return stats
+}
+
+// stats returns the statistics of every server.
+func (c *connCounter) stats() map[string]poolStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]poolStats, len(c.servers))
+	for address, s := range c.servers {
+		stats[address] = *s
+	}
+	return stats
+}
+
+// countedConn is a connection counted as open until it is closed.
+type countedConn struct {
+	net.Conn
+	counter *connCounter
+	address string
+	once    sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.counter.mu.Lock()
+		c.counter.server(c.address).Open--
+		c.counter.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
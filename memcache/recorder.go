@@ -0,0 +1,270 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func init() {
+	memcachemock.RegisterError("ErrNotFound", ErrNotFound)
+	memcachemock.RegisterError("ErrCodecMismatch", ErrCodecMismatch)
+	memcachemock.RegisterError("ErrMetaProtocolRequired", ErrMetaProtocolRequired)
+	memcachemock.RegisterError("ErrProtocol", ErrProtocol)
+	memcachemock.RegisterError("ErrNamespaceVersion", ErrNamespaceVersion)
+	memcachemock.RegisterError("ErrUnknownCompression", ErrUnknownCompression)
+	memcachemock.RegisterError("ErrDecompressedTooLarge", ErrDecompressedTooLarge)
+	memcachemock.RegisterError("ErrChunkIntegrity", ErrChunkIntegrity)
+	memcachemock.RegisterError("ErrChunkedValueTooLarge", ErrChunkedValueTooLarge)
+	memcachemock.RegisterError("ErrDecryption", ErrDecryption)
+	memcachemock.RegisterError("ErrServerWeights", ErrServerWeights)
+}
+
+// Recorder is a Client recording the operations of another client and
+// their results into a cassette, which memcachemock.MockServer.Replay
+// replays. Operations canceled by their context aren't recorded.
+type Recorder struct {
+	client Client
+
+	mu       sync.Mutex
+	cassette *memcachemock.Cassette
+	err      error
+}
+
+// NewRecorder creates a recorder of the operations of client.
+func NewRecorder(client Client) *Recorder {
+	return &Recorder{
+		client:   client,
+		cassette: memcachemock.NewCassette(),
+	}
+}
+
+// Cassette returns the operations recorded so far.
+func (r *Recorder) Cassette() (*memcachemock.Cassette, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	c := *r.cassette
+	c.Interactions = append([]memcachemock.Interaction(nil), r.cassette.Interactions...)
+	return &c, nil
+}
+
+// Save writes the operations recorded so far to a cassette file.
+func (r *Recorder) Save(path string) error {
+	c, err := r.Cassette()
+	if err != nil {
+		return err
+	}
+	return c.Save(path)
+}
+
+func (r *Recorder) record(op memcachemock.Operation, args memcachemock.Args, ret memcachemock.Return, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.cassette.Record(op, args, ret, err)
+	}
+}
+
+// itemArgs returns the arguments of an operation on an item, copying it
+// before the client updates its CAS ID.
+func itemArgs(it *item.Item) memcachemock.Args {
+	if it == nil {
+		return memcachemock.Args{it}
+	}
+	cp := *it
+	return memcachemock.Args{&cp}
+}
+
+func (r *Recorder) FlushAll() error {
+	return r.FlushAllContext(context.Background())
+}
+
+func (r *Recorder) Get(key string) (*item.Item, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+func (r *Recorder) Touch(key string, seconds int32) (err error) {
+	return r.TouchContext(context.Background(), key, seconds)
+}
+
+func (r *Recorder) GetMulti(keys []string) (map[string]*item.Item, error) {
+	return r.GetMultiContext(context.Background(), keys)
+}
+
+func (r *Recorder) Set(item *item.Item) error {
+	return r.SetContext(context.Background(), item)
+}
+
+func (r *Recorder) Add(item *item.Item) error {
+	return r.AddContext(context.Background(), item)
+}
+
+func (r *Recorder) Replace(item *item.Item) error {
+	return r.ReplaceContext(context.Background(), item)
+}
+
+func (r *Recorder) CompareAndSwap(item *item.Item) error {
+	return r.CompareAndSwapContext(context.Background(), item)
+}
+
+func (r *Recorder) Delete(key string) error {
+	return r.DeleteContext(context.Background(), key)
+}
+
+func (r *Recorder) DeleteAll() error {
+	return r.DeleteAllContext(context.Background())
+}
+
+func (r *Recorder) Ping() error {
+	return r.PingContext(context.Background())
+}
+
+func (r *Recorder) Increment(key string, delta uint64) (newValue uint64, err error) {
+	return r.IncrementContext(context.Background(), key, delta)
+}
+
+func (r *Recorder) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	return r.DecrementContext(context.Background(), key, delta)
+}
+
+func (r *Recorder) Exists(key string) (bool, error) {
+	return r.ExistsContext(context.Background(), key)
+}
+
+func (r *Recorder) FlushAllContext(ctx context.Context) error {
+	err := r.client.FlushAllContext(ctx)
+	r.record(memcachemock.OperationFlushAll, nil, nil, err)
+	return err
+}
+
+func (r *Recorder) GetContext(ctx context.Context, key string) (*item.Item, error) {
+	it, err := r.client.GetContext(ctx, key)
+	r.record(memcachemock.OperationGet, memcachemock.Args{key}, it, err)
+	return it, err
+}
+
+func (r *Recorder) TouchContext(ctx context.Context, key string, seconds int32) (err error) {
+	err = r.client.TouchContext(ctx, key, seconds)
+	r.record(memcachemock.OperationTouch, memcachemock.Args{key, seconds}, nil, err)
+	return err
+}
+
+func (r *Recorder) GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	items, err := r.client.GetMultiContext(ctx, keys)
+	r.record(memcachemock.OperationGetMulti, memcachemock.Args{keys}, items, err)
+	return items, err
+}
+
+func (r *Recorder) SetContext(ctx context.Context, item *item.Item) error {
+	args := itemArgs(item)
+	err := r.client.SetContext(ctx, item)
+	r.record(memcachemock.OperationSet, args, nil, err)
+	return err
+}
+
+func (r *Recorder) AddContext(ctx context.Context, item *item.Item) error {
+	args := itemArgs(item)
+	err := r.client.AddContext(ctx, item)
+	r.record(memcachemock.OperationAdd, args, nil, err)
+	return err
+}
+
+func (r *Recorder) ReplaceContext(ctx context.Context, item *item.Item) error {
+	args := itemArgs(item)
+	err := r.client.ReplaceContext(ctx, item)
+	r.record(memcachemock.OperationReplace, args, nil, err)
+	return err
+}
+
+func (r *Recorder) CompareAndSwapContext(ctx context.Context, item *item.Item) error {
+	args := itemArgs(item)
+	err := r.client.CompareAndSwapContext(ctx, item)
+	r.record(memcachemock.OperationCompareAndSwap, args, nil, err)
+	return err
+}
+
+func (r *Recorder) DeleteContext(ctx context.Context, key string) error {
+	err := r.client.DeleteContext(ctx, key)
+	r.record(memcachemock.OperationDelete, memcachemock.Args{key}, nil, err)
+	return err
+}
+
+func (r *Recorder) DeleteAllContext(ctx context.Context) error {
+	err := r.client.DeleteAllContext(ctx)
+	r.record(memcachemock.OperationDeleteAll, nil, nil, err)
+	return err
+}
+
+func (r *Recorder) PingContext(ctx context.Context) error {
+	err := r.client.PingContext(ctx)
+	r.record(memcachemock.OperationPing, nil, nil, err)
+	return err
+}
+
+func (r *Recorder) IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	newValue, err = r.client.IncrementContext(ctx, key, delta)
+	r.record(memcachemock.OperationIncrement, memcachemock.Args{key, delta}, newValue, err)
+	return newValue, err
+}
+
+func (r *Recorder) DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	newValue, err = r.client.DecrementContext(ctx, key, delta)
+	r.record(memcachemock.OperationDecrement, memcachemock.Args{key, delta}, newValue, err)
+	return newValue, err
+}
+
+func (r *Recorder) ExistsContext(ctx context.Context, key string) (bool, error) {
+	exists, err := r.client.ExistsContext(ctx, key)
+	r.record(memcachemock.OperationExists, memcachemock.Args{key}, exists, err)
+	return exists, err
+}
+
+func (r *Recorder) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	value, err := r.client.GetOrLoad(ctx, key, ttl, loader)
+	r.record(memcachemock.OperationGetOrLoad, memcachemock.Args{key}, value, err)
+	return value, err
+}
+
+func (r *Recorder) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	it, meta, err := r.client.MetaGet(ctx, key, opts)
+	r.record(memcachemock.OperationMetaGet, memcachemock.Args{key}, it, err)
+	return it, meta, err
+}
+
+func (r *Recorder) MetaSet(ctx context.Context, it *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	args := itemArgs(it)
+	meta, err := r.client.MetaSet(ctx, it, opts)
+	r.record(memcachemock.OperationMetaSet, args, meta, err)
+	return meta, err
+}
+
+func (r *Recorder) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	meta, err := r.client.MetaDelete(ctx, key, opts)
+	r.record(memcachemock.OperationMetaDelete, memcachemock.Args{key}, meta, err)
+	return meta, err
+}
+
+func (r *Recorder) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error) {
+	newValue, meta, err = r.client.MetaArithmetic(ctx, key, opts)
+	r.record(memcachemock.OperationMetaArithmetic, memcachemock.Args{key}, newValue, err)
+	return newValue, meta, err
+}
+
+func (r *Recorder) MetaNoop(ctx context.Context) error {
+	err := r.client.MetaNoop(ctx)
+	r.record(memcachemock.OperationMetaNoop, nil, nil, err)
+	return err
+}
@@ -0,0 +1,65 @@
+package memcache
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+var _ Client = (*Recorder)(nil)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// exercise runs the same operations against the recorded and the
+	// replaying client.
+	exercise := func(t *testing.T, client Client) {
+		if _, err := client.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar"), Expiration: 10}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if it, err := client.Get("foo"); err != nil || string(it.Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v (%v)", "bar", it, err)
+		}
+		if err := client.Add(&item.Item{Key: "foo", Value: []byte("baz")}); !errors.Is(err, memcache.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrNotStored, err)
+		}
+		client.Set(&item.Item{Key: "counter", Value: []byte("1")})
+		if value, err := client.Increment("counter", 2); err != nil || value != 3 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 3, value, err)
+		}
+		if items, err := client.GetMulti([]string{"foo", "counter", "missing"}); err != nil || len(items) != 2 {
+			t.Errorf("Expected %v items, got %v (%v)", 2, items, err)
+		}
+		if exists, err := client.Exists("missing"); err != nil || exists {
+			t.Errorf("Expected key not to exist, got %v (%v)", exists, err)
+		}
+	}
+
+	t.Run("Record", func(t *testing.T) {
+		recorder := NewRecorder(memcachemock.NewFakeClient())
+		exercise(t, recorder)
+		if err := recorder.Save(path); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		cassette, err := memcachemock.LoadCassette(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		server := memcachemock.NewMockServer(t)
+		if err := server.Replay(cassette); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		exercise(t, server.GetMockedClient())
+		server.AssertExpectations(t)
+	})
+}
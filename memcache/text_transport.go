@@ -0,0 +1,196 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// ErrMetaProtocolRequired is returned by the meta operations of a client
+// that was not built with UseMetaProtocol.
+var ErrMetaProtocolRequired = errors.New("memcache: operation requires the meta protocol")
+
+// textTransport sends the operations with the classic text protocol
+// through a github.com/bradfitz/gomemcache client.
+type textTransport struct {
+	mcClient *memcache.Client
+	conns    *connCounter
+}
+
+// poolStats returns the statistics of the connections to each server. The
+// idle connections of the client are unknown.
+func (t *textTransport) poolStats() map[string]poolStats {
+	return t.conns.stats()
+}
+
+func (t *textTransport) FlushAll(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return t.mcClient.FlushAll()
+	})
+}
+
+func (t *textTransport) Get(ctx context.Context, key string) (*item.Item, error) {
+	var it *memcache.Item
+	err := withContext(ctx, func() (err error) {
+		it, err = t.mcClient.Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	alias := (*item.Item)(it)
+	return alias, nil
+}
+
+func (t *textTransport) Touch(ctx context.Context, key string, seconds int32) error {
+	return withContext(ctx, func() error {
+		return t.mcClient.Touch(key, seconds)
+	})
+}
+
+func (t *textTransport) GetMulti(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	var multi map[string]*memcache.Item
+	err := withContext(ctx, func() (err error) {
+		multi, err = t.mcClient.GetMulti(keys)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]*item.Item)
+	for k, v := range multi {
+		items[k] = (*item.Item)(v)
+	}
+	return items, nil
+}
+
+func (t *textTransport) Set(ctx context.Context, item *item.Item) error {
+	alias := detach(ctx, item)
+	return withContext(ctx, func() error {
+		return t.mcClient.Set(alias)
+	})
+}
+
+func (t *textTransport) Add(ctx context.Context, item *item.Item) error {
+	alias := detach(ctx, item)
+	return withContext(ctx, func() error {
+		return t.mcClient.Add(alias)
+	})
+}
+
+func (t *textTransport) Replace(ctx context.Context, item *item.Item) error {
+	alias := detach(ctx, item)
+	return withContext(ctx, func() error {
+		return t.mcClient.Replace(alias)
+	})
+}
+
+func (t *textTransport) CompareAndSwap(ctx context.Context, item *item.Item) error {
+	alias := detach(ctx, item)
+	return withContext(ctx, func() error {
+		return t.mcClient.CompareAndSwap(alias)
+	})
+}
+
+func (t *textTransport) Delete(ctx context.Context, key string) error {
+	return withContext(ctx, func() error {
+		return t.mcClient.Delete(key)
+	})
+}
+
+func (t *textTransport) DeleteAll(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return t.mcClient.DeleteAll()
+	})
+}
+
+func (t *textTransport) Ping(ctx context.Context) error {
+	return withContext(ctx, func() error {
+		return t.mcClient.Ping()
+	})
+}
+
+func (t *textTransport) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	var value uint64
+	err := withContext(ctx, func() (err error) {
+		value, err = t.mcClient.Increment(key, delta)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func (t *textTransport) Decrement(ctx context.Context, key string, delta uint64) (uint64, error) {
+	var value uint64
+	err := withContext(ctx, func() (err error) {
+		value, err = t.mcClient.Decrement(key, delta)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func (t *textTransport) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	return nil, nil, ErrMetaProtocolRequired
+}
+
+func (t *textTransport) MetaSet(ctx context.Context, item *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	return nil, ErrMetaProtocolRequired
+}
+
+func (t *textTransport) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	return nil, ErrMetaProtocolRequired
+}
+
+func (t *textTransport) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (uint64, *item.Meta, error) {
+	return 0, nil, ErrMetaProtocolRequired
+}
+
+func (t *textTransport) MetaNoop(ctx context.Context) error {
+	return ErrMetaProtocolRequired
+}
+
+// detach returns the item given to the underlying client by a write with
+// ctx. A write abandoned by withContext keeps running in the background,
+// so it is given a copy of the item and its value when ctx can be done,
+// which the caller is free to change once the write returns.
+func detach(ctx context.Context, it *item.Item) *memcache.Item {
+	if ctx.Done() == nil || it == nil {
+		return (*memcache.Item)(it)
+	}
+	cp := *(*memcache.Item)(it)
+	cp.Value = append([]byte(nil), it.Value...)
+	return &cp
+}
+
+// withContext runs fn and waits for it to finish or for ctx to be done,
+// whichever happens first. The underlying client has no notion of
+// contexts, so a call abandoned because of ctx keeps running in the
+// background until it completes or hits the socket timeout. An abandoned
+// write may therefore still reach the servers after ctx.Err() is returned.
+func withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
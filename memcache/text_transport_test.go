@@ -0,0 +1,126 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+	"github.com/getmiranda/gomemcached/memcachetest"
+)
+
+func TestWithContext(t *testing.T) {
+
+	t.Run("CanceledBeforeCall", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		called := false
+		err := withContext(ctx, func() error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected error to be %v, got %v", context.Canceled, err)
+		}
+		if called {
+			t.Errorf("Expected fn not to be called")
+		}
+	})
+
+	t.Run("DeadlineExceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+
+		release := make(chan struct{})
+		defer close(release)
+
+		err := withContext(ctx, func() error {
+			<-release
+			return nil
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected error to be %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("ReturnsFnError", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		want := errors.New("mirandas")
+		err := withContext(ctx, func() error {
+			return want
+		})
+		if !errors.Is(err, want) {
+			t.Errorf("Expected error to be %v, got %v", want, err)
+		}
+	})
+}
+
+func TestDetach(t *testing.T) {
+
+	t.Run("Copy", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		it := &item.Item{Key: "foo", Value: []byte("bar"), Flags: 42}
+		detached := detach(ctx, it)
+		it.Key = "other"
+		it.Value[0] = 'c'
+
+		if detached.Key != "foo" || string(detached.Value) != "bar" || detached.Flags != 42 {
+			t.Errorf("Expected item to be foo=bar with flags 42, got %v=%v with flags %v", detached.Key, string(detached.Value), detached.Flags)
+		}
+	})
+
+	t.Run("Background", func(t *testing.T) {
+		it := &item.Item{Key: "foo", Value: []byte("bar")}
+		if detached := detach(context.Background(), it); detached != (*memcache.Item)(it) {
+			t.Errorf("Expected item not to be copied without a context that can be done")
+		}
+	})
+}
+
+func TestTextTransport(t *testing.T) {
+	if memcachemock.MockupServer.IsEnabled() {
+		memcachemock.MockupServer.Stop()
+		defer memcachemock.MockupServer.Start()
+	}
+
+	t.Run("AbandonedWrite", func(t *testing.T) {
+		server := memcachetest.NewServer(t)
+		server.InjectFault(memcachemock.Fault{
+			Operations: []memcachemock.Operation{memcachemock.OperationSet},
+			Delay:      time.Millisecond * 100,
+		})
+		client := NewBuilder().WithServers(server.Addr()).Build()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		it := &item.Item{Key: "foo", Value: []byte("bar")}
+		if err := client.SetContext(ctx, it); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected error to be %v, got %v", context.DeadlineExceeded, err)
+		}
+		it.Value[0] = 'c'
+
+		// The abandoned write still completes, with the value it was given.
+		deadline := time.Now().Add(time.Second)
+		for {
+			found, err := client.Get("foo")
+			if err == nil {
+				if string(found.Value) != "bar" {
+					t.Errorf("Expected value to be %v, got %v", "bar", string(found.Value))
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the abandoned write to complete, got %v", err)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	})
+}
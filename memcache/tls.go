@@ -0,0 +1,54 @@
+package memcache
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+)
+
+// tlsDialer returns a dial func wrapping the connections dialed by dial in
+// TLS with config. Without a server name in config, the host of the
+// address is verified.
+func tlsDialer(dial dialFunc, config *tls.Config) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		nc, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := config
+		if cfg.ServerName == "" {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				host = address
+			}
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+		tc := tls.Client(nc, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
+
+// tlsServerName returns the host name shared by the servers, if any. The
+// servers are dialed at their IP address, so the host name is otherwise
+// lost for SNI and the verification of their certificate.
+func tlsServerName(servers []string) string {
+	name := ""
+	for _, server := range servers {
+		if strings.Contains(server, "/") {
+			return ""
+		}
+		host, _, err := net.SplitHostPort(server)
+		if err != nil || net.ParseIP(host) != nil || (name != "" && host != name) {
+			return ""
+		}
+		name = host
+	}
+	return name
+}
@@ -0,0 +1,210 @@
+package memcache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+	"github.com/getmiranda/gomemcached/memcachetest"
+)
+
+// tlsConnections records the TLS connections accepted by a server.
+type tlsConnections struct {
+	mu     sync.Mutex
+	states []tls.ConnectionState
+}
+
+func (c *tlsConnections) verify(state tls.ConnectionState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.states = append(c.states, state)
+	return nil
+}
+
+func (c *tlsConnections) get() []tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]tls.ConnectionState(nil), c.states...)
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// localhost returns the address of server with the localhost host name.
+func localhost(t *testing.T, addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+func TestTLS(t *testing.T) {
+	if memcachemock.MockupServer.IsEnabled() {
+		memcachemock.MockupServer.Stop()
+		defer memcachemock.MockupServer.Start()
+	}
+
+	t.Run("ServerName", func(t *testing.T) {
+		conns := new(tlsConnections)
+		server := memcachetest.NewTLSServer(t, &tls.Config{VerifyConnection: conns.verify})
+		client := NewBuilder().
+			WithServers(localhost(t, server.Addr())).
+			WithTLSConfig(&tls.Config{RootCAs: certPool(server.Certificate())}).
+			Build()
+
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v", "bar", string(it.Value))
+		}
+
+		states := conns.get()
+		if len(states) == 0 {
+			t.Fatalf("Expected a TLS connection, got none")
+		}
+		if states[0].ServerName != "localhost" {
+			t.Errorf("Expected server name to be %v, got %v", "localhost", states[0].ServerName)
+		}
+	})
+
+	t.Run("UntrustedServer", func(t *testing.T) {
+		server := memcachetest.NewTLSServer(t, nil)
+		client := NewBuilder().
+			WithServers(server.Addr()).
+			WithTLSConfig(&tls.Config{}).
+			Build()
+
+		if err := client.Ping(); err == nil {
+			t.Errorf("Expected an error, got nil")
+		}
+	})
+
+	t.Run("ClientCertificates", func(t *testing.T) {
+		clientCert, err := memcachetest.NewCertificate("client")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		server := memcachetest.NewTLSServer(t, &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  certPool(clientCert.Leaf),
+		})
+
+		client := NewBuilder().
+			WithServers(server.Addr()).
+			WithTLSConfig(&tls.Config{
+				RootCAs:      certPool(server.Certificate()),
+				Certificates: []tls.Certificate{clientCert},
+			}).
+			Build()
+		if err := client.Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		anonymous := NewBuilder().
+			WithServers(server.Addr()).
+			WithTLSConfig(&tls.Config{RootCAs: certPool(server.Certificate())}).
+			Build()
+		if err := anonymous.Ping(); err == nil {
+			t.Errorf("Expected an error without client certificate, got nil")
+		}
+	})
+
+	t.Run("SessionResumption", func(t *testing.T) {
+		conns := new(tlsConnections)
+		server := memcachetest.NewTLSServer(t, &tls.Config{VerifyConnection: conns.verify})
+		config := &tls.Config{
+			RootCAs:            certPool(server.Certificate()),
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		}
+
+		for i := 0; i < 2; i++ {
+			client := NewBuilder().WithServers(server.Addr()).WithTLSConfig(config).Build()
+			if _, err := client.Exists("foo"); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+
+		states := conns.get()
+		if len(states) != 2 {
+			t.Fatalf("Expected %v TLS connections, got %v", 2, len(states))
+		}
+		if states[0].DidResume || !states[1].DidResume {
+			t.Errorf("Expected the second connection to resume the session of the first one")
+		}
+	})
+
+	t.Run("MetaProtocol", func(t *testing.T) {
+		cert, err := memcachetest.NewCertificate("127.0.0.1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer l.Close()
+		go func() {
+			for {
+				nc, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func(nc net.Conn) {
+					defer nc.Close()
+					r := bufio.NewReader(nc)
+					for {
+						if _, err := r.ReadString('\n'); err != nil {
+							return
+						}
+						nc.Write([]byte("MN\r\n"))
+					}
+				}(nc)
+			}
+		}()
+
+		client := NewBuilder().
+			WithServers(l.Addr().String()).
+			UseMetaProtocol().
+			WithTLSConfig(&tls.Config{RootCAs: certPool(cert.Leaf)}).
+			Build()
+		if err := client.Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestTLSServerName(t *testing.T) {
+	tests := []struct {
+		servers  []string
+		expected string
+	}{
+		{[]string{"cache.example.com:11211"}, "cache.example.com"},
+		{[]string{"cache.example.com:11211", "cache.example.com:11212"}, "cache.example.com"},
+		{[]string{"a.example.com:11211", "b.example.com:11211"}, ""},
+		{[]string{"127.0.0.1:11211"}, ""},
+		{[]string{"/var/run/memcached.sock"}, ""},
+	}
+	for _, tt := range tests {
+		if name := tlsServerName(tt.servers); name != tt.expected {
+			t.Errorf("Expected server name of %v to be %q, got %q", tt.servers, tt.expected, name)
+		}
+	}
+}
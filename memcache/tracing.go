@@ -0,0 +1,97 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/getmiranda/gomemcached/memcache"
+
+// Attributes of the spans of the operations, from the OpenTelemetry
+// database semantic conventions where they have one.
+const (
+	attrDBSystem      = attribute.Key("db.system")
+	attrDBOperation   = attribute.Key("db.operation.name")
+	attrServerAddress = attribute.Key("server.address")
+	attrServerPort    = attribute.Key("server.port")
+	attrErrorType     = attribute.Key("error.type")
+	attrKeyCount      = attribute.Key("memcached.key_count")
+	attrHit           = attribute.Key("memcached.hit")
+	attrHits          = attribute.Key("memcached.hits")
+	attrValueSize     = attribute.Key("memcached.value_size")
+)
+
+// newTracingInterceptor returns an interceptor recording a span for every
+// operation. selector, if not nil, picks the server of the keys.
+func newTracingInterceptor(tp trace.TracerProvider, selector memcache.ServerSelector) Interceptor {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(tracerName)
+
+	return func(ctx context.Context, call *Call, invoker Invoker) error {
+		attrs := []attribute.KeyValue{
+			attrDBSystem.String("memcached"),
+			attrDBOperation.String(call.Operation),
+		}
+		if len(call.Keys) > 0 {
+			attrs = append(attrs, attrKeyCount.Int(len(call.Keys)))
+		}
+		if selector != nil && len(call.Keys) == 1 {
+			if addr, err := selector.PickServer(call.Keys[0]); err == nil {
+				attrs = append(attrs, serverAttributes(addr)...)
+			}
+		}
+		if call.Item != nil {
+			attrs = append(attrs, attrValueSize.Int(len(call.Item.Value)))
+		}
+
+		ctx, span := tracer.Start(ctx, call.Operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		err := invoker(ctx, call)
+		switch call.Operation {
+		case "Get", "MetaGet":
+			span.SetAttributes(attrHit.Bool(err == nil))
+			if err == nil && call.Item != nil {
+				span.SetAttributes(attrValueSize.Int(len(call.Item.Value)))
+			}
+		case "Exists":
+			span.SetAttributes(attrHit.Bool(call.Exists))
+		case "GetMulti":
+			size := 0
+			for _, it := range call.Items {
+				size += len(it.Value)
+			}
+			span.SetAttributes(attrHits.Int(len(call.Items)), attrValueSize.Int(size))
+		}
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			span.SetAttributes(attrErrorType.String(errorType(err)))
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func serverAttributes(addr net.Addr) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []attribute.KeyValue{attrServerAddress.String(addr.String())}
+	}
+	attrs := []attribute.KeyValue{attrServerAddress.String(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, attrServerPort.Int(p))
+	}
+	return attrs
+}
@@ -0,0 +1,129 @@
+package memcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)), recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+
+	t.Run("Spans", func(t *testing.T) {
+		tp, recorder := newTestTracerProvider()
+		client := NewBuilder().
+			WithClient(memcachemock.NewFakeClient()).
+			WithTracing(tp).
+			Build()
+
+		ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+		client.SetContext(ctx, &item.Item{Key: "foo", Value: []byte("bar")})
+		client.GetContext(ctx, "foo")
+		client.GetContext(ctx, "missing")
+		client.GetMultiContext(ctx, []string{"foo", "missing"})
+		parent.End()
+
+		spans := recorder.Ended()
+		if len(spans) != 5 {
+			t.Fatalf("Expected %v spans, got %v", 5, len(spans))
+		}
+		for _, span := range spans[:4] {
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("Expected span %v to be a child of the parent span", span.Name())
+			}
+			if span.SpanKind() != trace.SpanKindClient {
+				t.Errorf("Expected span kind to be %v, got %v", trace.SpanKindClient, span.SpanKind())
+			}
+		}
+
+		tests := []struct {
+			span     int
+			name     string
+			expected map[attribute.Key]attribute.Value
+		}{
+			{0, "Set", map[attribute.Key]attribute.Value{
+				attrDBSystem:    attribute.StringValue("memcached"),
+				attrDBOperation: attribute.StringValue("Set"),
+				attrValueSize:   attribute.IntValue(3),
+			}},
+			{1, "Get", map[attribute.Key]attribute.Value{
+				attrKeyCount:  attribute.IntValue(1),
+				attrHit:       attribute.BoolValue(true),
+				attrValueSize: attribute.IntValue(3),
+			}},
+			{2, "Get", map[attribute.Key]attribute.Value{
+				attrHit: attribute.BoolValue(false),
+			}},
+			{3, "GetMulti", map[attribute.Key]attribute.Value{
+				attrKeyCount: attribute.IntValue(2),
+				attrHits:     attribute.IntValue(1),
+			}},
+		}
+		for _, tt := range tests {
+			span := spans[tt.span]
+			if span.Name() != tt.name {
+				t.Errorf("Expected span name to be %v, got %v", tt.name, span.Name())
+			}
+			attrs := spanAttributes(span)
+			for key, value := range tt.expected {
+				if attrs[key] != value {
+					t.Errorf("%s: Expected %v to be %v, got %v", tt.name, key, value.Emit(), attrs[key].Emit())
+				}
+			}
+			if span.Status().Code == codes.Error {
+				t.Errorf("%s: Expected span not to be an error", tt.name)
+			}
+		}
+	})
+
+	t.Run("ServerAndError", func(t *testing.T) {
+		// Build a real client even if a previous test started the mock.
+		if memcachemock.MockupServer.IsEnabled() {
+			memcachemock.MockupServer.Stop()
+			defer memcachemock.MockupServer.Start()
+		}
+		tp, recorder := newTestTracerProvider()
+		// Nothing listens on port 1.
+		client := NewBuilder().
+			WithServers("127.0.0.1:1").
+			WithTracing(tp).
+			Build()
+
+		if _, err := client.GetContext(context.Background(), "foo"); err == nil {
+			t.Fatalf("Expected an error")
+		}
+		spans := recorder.Ended()
+		if len(spans) != 1 {
+			t.Fatalf("Expected %v spans, got %v", 1, len(spans))
+		}
+		attrs := spanAttributes(spans[0])
+		if attrs[attrServerAddress].AsString() != "127.0.0.1" || attrs[attrServerPort].AsInt64() != 1 {
+			t.Errorf("Expected server to be %v, got %v:%v", "127.0.0.1:1", attrs[attrServerAddress].Emit(), attrs[attrServerPort].Emit())
+		}
+		if attrs[attrErrorType].AsString() != "network_error" {
+			t.Errorf("Expected error type to be %v, got %v", "network_error", attrs[attrErrorType].Emit())
+		}
+		if spans[0].Status().Code != codes.Error {
+			t.Errorf("Expected span to be an error")
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package memcache
+
+import (
+	"context"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// transport sends the client operations to the servers using one of the
+// memcached protocols.
+type transport interface {
+	FlushAll(ctx context.Context) error
+	Get(ctx context.Context, key string) (*item.Item, error)
+	Touch(ctx context.Context, key string, seconds int32) error
+	GetMulti(ctx context.Context, keys []string) (map[string]*item.Item, error)
+	Set(ctx context.Context, item *item.Item) error
+	Add(ctx context.Context, item *item.Item) error
+	Replace(ctx context.Context, item *item.Item) error
+	CompareAndSwap(ctx context.Context, item *item.Item) error
+	Delete(ctx context.Context, key string) error
+	DeleteAll(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Increment(ctx context.Context, key string, delta uint64) (uint64, error)
+	Decrement(ctx context.Context, key string, delta uint64) (uint64, error)
+
+	MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error)
+	MetaSet(ctx context.Context, item *item.Item, opts *item.MetaSetOptions) (*item.Meta, error)
+	MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error)
+	MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (uint64, *item.Meta, error)
+	MetaNoop(ctx context.Context) error
+
+	// poolStats returns the statistics of the connections to each server.
+	poolStats() map[string]poolStats
+}
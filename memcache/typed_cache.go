@@ -0,0 +1,117 @@
+package memcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// maxRelativeExpiration is the largest expiration memcached interprets as
+// a number of seconds into the future. Larger values are Unix timestamps.
+const maxRelativeExpiration = time.Hour * 24 * 30
+
+// TypedCache stores values of type T in memcached, encoding them with a
+// Codec. The codec identifier is recorded in the item flags, so reading
+// an item written with another codec fails with ErrCodecMismatch instead
+// of decoding garbage.
+type TypedCache[T any] struct {
+	client Client
+	codec  Codec
+}
+
+// NewTypedCache creates a TypedCache on top of client using codec.
+func NewTypedCache[T any](client Client, codec Codec) *TypedCache[T] {
+	return &TypedCache[T]{
+		client: client,
+		codec:  codec,
+	}
+}
+
+// Get gets and decodes the value for the given key. ErrCacheMiss is
+// returned for a memcache cache miss.
+func (c *TypedCache[T]) Get(key string) (T, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// Set encodes and writes the value for the given key, unconditionally.
+// A zero ttl means the item has no expiration time.
+func (c *TypedCache[T]) Set(key string, value T, ttl time.Duration) error {
+	return c.SetContext(context.Background(), key, value, ttl)
+}
+
+// GetMulti is a batch version of Get. The returned map may have fewer
+// elements than the input slice, due to memcache cache misses.
+func (c *TypedCache[T]) GetMulti(keys []string) (map[string]T, error) {
+	return c.GetMultiContext(context.Background(), keys)
+}
+
+// GetContext is like Get but honors the deadline and cancellation of ctx.
+func (c *TypedCache[T]) GetContext(ctx context.Context, key string) (T, error) {
+	var value T
+	it, err := c.client.GetContext(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	return c.decode(it)
+}
+
+// SetContext is like Set but honors the deadline and cancellation of ctx.
+func (c *TypedCache[T]) SetContext(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.client.SetContext(ctx, &item.Item{
+		Key:        key,
+		Value:      data,
+		Flags:      c.codec.ID() & codecFlagsMask,
+		Expiration: expiration(ttl),
+	})
+}
+
+// GetMultiContext is like GetMulti but honors the deadline and
+// cancellation of ctx.
+func (c *TypedCache[T]) GetMultiContext(ctx context.Context, keys []string) (map[string]T, error) {
+	items, err := c.client.GetMultiContext(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]T, len(items))
+	for k, it := range items {
+		value, err := c.decode(it)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = value
+	}
+	return values, nil
+}
+
+func (c *TypedCache[T]) decode(it *item.Item) (T, error) {
+	var value T
+	if id := it.Flags & codecFlagsMask; id != c.codec.ID()&codecFlagsMask {
+		return value, fmt.Errorf("%w: item %q was written with codec %d, want %d",
+			ErrCodecMismatch, it.Key, id, c.codec.ID())
+	}
+	if err := c.codec.Unmarshal(it.Value, &value); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// expiration converts ttl to the value memcached expects in
+// item.Item.Expiration.
+func expiration(ttl time.Duration) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > maxRelativeExpiration {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+	if ttl < time.Second {
+		return 1
+	}
+	return int32(ttl / time.Second)
+}
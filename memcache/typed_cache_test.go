@@ -0,0 +1,135 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+type typedCacheUser struct {
+	Name string
+	Age  int
+}
+
+type typedCacheProto struct {
+	data string
+}
+
+func (p *typedCacheProto) Marshal() ([]byte, error) {
+	return []byte(p.data), nil
+}
+
+func (p *typedCacheProto) Unmarshal(data []byte) error {
+	p.data = string(data)
+	return nil
+}
+
+func TestTypedCache(t *testing.T) {
+	memcachemock.MockupServer.Start()
+	defer memcachemock.MockupServer.Stop()
+
+	client := NewBuilder().Build()
+
+	t.Run("Get", func(t *testing.T) {
+		memcachemock.MockupServer.DeleteMocks()
+		memcachemock.MockupServer.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationGet,
+			Args:      memcachemock.Args{"user:1"},
+
+			Return: &item.Item{
+				Key:   "user:1",
+				Value: []byte(`{"Name":"miranda","Age":30}`),
+				Flags: CodecJSON,
+			},
+		})
+
+		cache := NewTypedCache[typedCacheUser](client, JSONCodec{})
+		user, err := cache.Get("user:1")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if user.Name != "miranda" || user.Age != 30 {
+			t.Errorf("Expected user to be %v, got %v", typedCacheUser{"miranda", 30}, user)
+		}
+	})
+
+	t.Run("GetCodecMismatch", func(t *testing.T) {
+		memcachemock.MockupServer.DeleteMocks()
+		memcachemock.MockupServer.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationGet,
+			Args:      memcachemock.Args{"user:1"},
+
+			Return: &item.Item{
+				Key:   "user:1",
+				Value: []byte(`{"Name":"miranda","Age":30}`),
+				Flags: CodecJSON,
+			},
+		})
+
+		cache := NewTypedCache[typedCacheUser](client, GobCodec{})
+		if _, err := cache.Get("user:1"); !errors.Is(err, ErrCodecMismatch) {
+			t.Errorf("Expected error to be %v, got %v", ErrCodecMismatch, err)
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		memcachemock.MockupServer.DeleteMocks()
+		memcachemock.MockupServer.AddMock(&memcachemock.Mock{
+			Operation: memcachemock.OperationGetMulti,
+			Args:      memcachemock.Args{[]string{"a", "b"}},
+
+			Return: map[string]*item.Item{
+				"a": {Key: "a", Value: []byte("1"), Flags: CodecJSON},
+				"b": {Key: "b", Value: []byte("2"), Flags: CodecJSON},
+			},
+		})
+
+		cache := NewTypedCache[int](client, JSONCodec{})
+		values, err := cache.GetMulti([]string{"a", "b"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(values) != 2 || values["a"] != 1 || values["b"] != 2 {
+			t.Errorf("Expected values to be %v, got %v", map[string]int{"a": 1, "b": 2}, values)
+		}
+	})
+}
+
+func TestCodecs(t *testing.T) {
+
+	t.Run("Gob", func(t *testing.T) {
+		data, err := GobCodec{}.Marshal(typedCacheUser{"miranda", 30})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var user typedCacheUser
+		if err := (GobCodec{}).Unmarshal(data, &user); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if user.Name != "miranda" || user.Age != 30 {
+			t.Errorf("Expected user to be %v, got %v", typedCacheUser{"miranda", 30}, user)
+		}
+	})
+
+	t.Run("ProtoPointer", func(t *testing.T) {
+		data, err := ProtoCodec{}.Marshal(&typedCacheProto{"miranda"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var msg *typedCacheProto
+		if err := (ProtoCodec{}).Unmarshal(data, &msg); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if msg == nil || msg.data != "miranda" {
+			t.Errorf("Expected message data to be %v, got %v", "miranda", msg)
+		}
+	})
+
+	t.Run("ProtoNotMessage", func(t *testing.T) {
+		if _, err := (ProtoCodec{}).Marshal(42); !errors.Is(err, ErrNotProtoMessage) {
+			t.Errorf("Expected error to be %v, got %v", ErrNotProtoMessage, err)
+		}
+	})
+}
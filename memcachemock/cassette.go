@@ -0,0 +1,210 @@
+package memcachemock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// CassetteVersion is the version of the cassette format written by this
+// package.
+const CassetteVersion = 1
+
+var ErrCassetteVersion = errors.New("unsupported cassette version")
+
+// Cassette holds recorded operations and their results, to be replayed
+// by a mock server.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded operation. The fields holding its arguments
+// depend on the operation, as in the Args of a mock. Return holds the
+// result of the operation as JSON, and Error the name of the error, if it
+// is a registered one, or its message.
+type Interaction struct {
+	Operation Operation  `json:"operation"`
+	Key       string     `json:"key,omitempty"`
+	Keys      []string   `json:"keys,omitempty"`
+	Item      *item.Item `json:"item,omitempty"`
+	Seconds   int32      `json:"seconds,omitempty"`
+	Delta     uint64     `json:"delta,omitempty"`
+
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// NewCassette creates an empty cassette.
+func NewCassette() *Cassette {
+	return &Cassette{Version: CassetteVersion}
+}
+
+// ReadCassette reads a cassette written with Cassette.Write.
+func ReadCassette(r io.Reader) (*Cassette, error) {
+	var c Cassette
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("%w: %d", ErrCassetteVersion, c.Version)
+	}
+	return &c, nil
+}
+
+// LoadCassette reads the cassette of a file.
+func LoadCassette(path string) (*Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCassette(f)
+}
+
+// Write writes the cassette as JSON.
+func (c *Cassette) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// Save writes the cassette to a file.
+func (c *Cassette) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Record adds an operation, called with the arguments a mock for it
+// takes, and its result.
+func (c *Cassette) Record(op Operation, args Args, ret Return, err error) error {
+	in := Interaction{Operation: op}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			in.Key = v
+		case []string:
+			in.Keys = v
+		case *item.Item:
+			if v != nil {
+				cp := *v
+				v = &cp
+			}
+			in.Item = v
+		case int32:
+			in.Seconds = v
+		case uint64:
+			in.Delta = v
+		default:
+			return fmt.Errorf("memcachemock: can't record %s argument of type %T", op, arg)
+		}
+	}
+	if err != nil {
+		in.Error = err.Error()
+		if name, ok := ErrorName(err); ok {
+			in.Error = name
+		}
+	} else if ret != nil {
+		data, err := json.Marshal(ret)
+		if err != nil {
+			return err
+		}
+		in.Return = data
+	}
+	c.Interactions = append(c.Interactions, in)
+	return nil
+}
+
+// args returns the arguments of the mock for the interaction.
+func (in *Interaction) args() Args {
+	switch in.Operation {
+	case OperationFlushAll, OperationDeleteAll, OperationPing, OperationMetaNoop:
+		return nil
+	case OperationGetMulti:
+		return Args{in.Keys}
+	case OperationSet, OperationAdd, OperationReplace, OperationCompareAndSwap, OperationMetaSet:
+		return Args{in.Item}
+	case OperationTouch:
+		return Args{in.Key, in.Seconds}
+	case OperationIncrement, OperationDecrement:
+		return Args{in.Key, in.Delta}
+	}
+	return Args{in.Key}
+}
+
+// response returns the response of the mock for the interaction.
+func (in *Interaction) response() (Response, error) {
+	if in.Error != "" {
+		err, ok := NamedError(in.Error)
+		if !ok {
+			err = errors.New(in.Error)
+		}
+		return Response{Error: err}, nil
+	}
+	if len(in.Return) == 0 {
+		return Response{}, nil
+	}
+
+	var ret interface{}
+	switch in.Operation {
+	case OperationGet, OperationMetaGet:
+		ret = new(*item.Item)
+	case OperationGetMulti:
+		ret = new(map[string]*item.Item)
+	case OperationIncrement, OperationDecrement, OperationMetaArithmetic:
+		ret = new(uint64)
+	case OperationExists:
+		ret = new(bool)
+	case OperationGetOrLoad:
+		ret = new([]byte)
+	case OperationMetaSet, OperationMetaDelete:
+		ret = new(*item.Meta)
+	default:
+		return Response{}, fmt.Errorf("memcachemock: %s doesn't return a value", in.Operation)
+	}
+	if err := json.Unmarshal(in.Return, ret); err != nil {
+		return Response{}, fmt.Errorf("memcachemock: decoding %s return: %w", in.Operation, err)
+	}
+	// Dereference the pointer allocated to decode the value.
+	return Response{Return: reflect.ValueOf(ret).Elem().Interface()}, nil
+}
+
+// Replay adds mocks answering the operations of the cassette with their
+// recorded results. Operations recorded several times with the same
+// arguments return their results in order.
+func (m *MockServer) Replay(c *Cassette) error {
+	mocks := make(map[string]*Mock)
+	var order []*Mock
+	for i := range c.Interactions {
+		in := &c.Interactions[i]
+		resp, err := in.response()
+		if err != nil {
+			return fmt.Errorf("interaction %d: %w", i, err)
+		}
+		args := in.args()
+		key := m.getMockKey(in.Operation, args)
+		mock, ok := mocks[key]
+		if !ok {
+			mock = &Mock{Operation: in.Operation, Args: args}
+			mocks[key] = mock
+			order = append(order, mock)
+		}
+		mock.Responses = append(mock.Responses, resp)
+	}
+	for _, mock := range order {
+		m.AddMock(mock)
+	}
+	return nil
+}
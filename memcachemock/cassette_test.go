@@ -0,0 +1,62 @@
+package memcachemock
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+func TestCassette(t *testing.T) {
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		c := NewCassette()
+		c.Record(OperationGet, Args{"foo"}, nil, memcache.ErrCacheMiss)
+		c.Record(OperationGet, Args{"foo"}, &item.Item{Key: "foo", Value: []byte("bar")}, nil)
+		c.Record(OperationTouch, Args{"foo", int32(10)}, nil, errors.New("boom"))
+
+		var buf bytes.Buffer
+		if err := c.Write(&buf); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		read, err := ReadCassette(&buf)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		server := NewMockServer(t)
+		if err := server.Replay(read); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		client := server.GetMockedClient()
+		if _, err := client.Get("foo"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if it, err := client.Get("foo"); err != nil || string(it.Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v (%v)", "bar", it, err)
+		}
+		if err := client.Touch("foo", 10); err == nil || err.Error() != "boom" {
+			t.Errorf("Expected error to be %v, got %v", "boom", err)
+		}
+	})
+
+	t.Run("UnsupportedVersion", func(t *testing.T) {
+		_, err := ReadCassette(strings.NewReader(`{"version": 2, "interactions": []}`))
+		if !errors.Is(err, ErrCassetteVersion) {
+			t.Errorf("Expected error to be %v, got %v", ErrCassetteVersion, err)
+		}
+	})
+
+	t.Run("InvalidReturn", func(t *testing.T) {
+		c, err := ReadCassette(strings.NewReader(`{"version": 1, "interactions": [{"operation": "Increment", "key": "foo", "return": "bar"}]}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := NewMockServer(t).Replay(c); err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+}
@@ -1,14 +1,84 @@
 package memcachemock
 
 import (
+	"context"
+	"time"
+
 	"github.com/getmiranda/gomemcached/item"
 )
 
-type clientMock struct{}
+// MockClient is the client of a MockServer. Its operations answer with
+// the mocks of the server, or ErrMockNotFound.
+type MockClient struct {
+	server  *MockServer
+	timeout time.Duration
+}
+
+// WithTimeout returns a client of the same server whose calls delayed
+// longer than timeout by injected faults fail with ErrTimeout, like a
+// client built with memcache.ClientBuilder.SetTimeout.
+func (c *MockClient) WithTimeout(timeout time.Duration) *MockClient {
+	return &MockClient{server: c.server, timeout: timeout}
+}
+
+func (c *MockClient) FlushAll() error {
+	return c.FlushAllContext(context.Background())
+}
+
+func (c *MockClient) Get(key string) (*item.Item, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *MockClient) Touch(key string, seconds int32) (err error) {
+	return c.TouchContext(context.Background(), key, seconds)
+}
+
+func (c *MockClient) GetMulti(keys []string) (map[string]*item.Item, error) {
+	return c.GetMultiContext(context.Background(), keys)
+}
+
+func (c *MockClient) Set(item *item.Item) error {
+	return c.SetContext(context.Background(), item)
+}
+
+func (c *MockClient) Add(item *item.Item) error {
+	return c.AddContext(context.Background(), item)
+}
+
+func (c *MockClient) Replace(item *item.Item) error {
+	return c.ReplaceContext(context.Background(), item)
+}
+
+func (c *MockClient) CompareAndSwap(item *item.Item) error {
+	return c.CompareAndSwapContext(context.Background(), item)
+}
+
+func (c *MockClient) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+func (c *MockClient) DeleteAll() error {
+	return c.DeleteAllContext(context.Background())
+}
+
+func (c *MockClient) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *MockClient) Increment(key string, delta uint64) (newValue uint64, err error) {
+	return c.IncrementContext(context.Background(), key, delta)
+}
+
+func (c *MockClient) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	return c.DecrementContext(context.Background(), key, delta)
+}
+
+func (c *MockClient) Exists(key string) (bool, error) {
+	return c.ExistsContext(context.Background(), key)
+}
 
-func (c *clientMock) FlushAll() error {
-	key := MockupServer.getMockKey(OperationFlushAll)
-	mock := MockupServer.mocks[key]
+func (c *MockClient) FlushAllContext(ctx context.Context) error {
+	mock := c.server.call(ctx, c.timeout, OperationFlushAll)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -18,10 +88,9 @@ func (c *clientMock) FlushAll() error {
 	return nil
 }
 
-func (c *clientMock) Get(key string) (*item.Item, error) {
+func (c *MockClient) GetContext(ctx context.Context, key string) (*item.Item, error) {
 	args := Args{key}
-	mockKey := MockupServer.getMockKey(OperationGet, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationGet, args)
 	if mock == nil {
 		return nil, ErrMockNotFound
 	}
@@ -35,10 +104,9 @@ func (c *clientMock) Get(key string) (*item.Item, error) {
 	return item, nil
 }
 
-func (c *clientMock) Touch(key string, seconds int32) (err error) {
+func (c *MockClient) TouchContext(ctx context.Context, key string, seconds int32) (err error) {
 	args := Args{key, seconds}
-	mockKey := MockupServer.getMockKey(OperationTouch, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationTouch, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -48,15 +116,16 @@ func (c *clientMock) Touch(key string, seconds int32) (err error) {
 	return nil
 }
 
-func (c *clientMock) GetMulti(keys []string) (map[string]*item.Item, error) {
+func (c *MockClient) GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error) {
 	args := Args{keys}
-	mockKey := MockupServer.getMockKey(OperationGetMulti, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationGetMulti, args)
 	if mock == nil {
 		return nil, ErrMockNotFound
 	}
 	if mock.Error != nil {
-		return nil, mock.Error
+		// Partial failures return the items found along with the error.
+		items, _ := mock.Return.(map[string]*item.Item)
+		return items, mock.Error
 	}
 	items, ok := mock.Return.(map[string]*item.Item)
 	if !ok {
@@ -65,10 +134,9 @@ func (c *clientMock) GetMulti(keys []string) (map[string]*item.Item, error) {
 	return items, nil
 }
 
-func (c *clientMock) Set(item *item.Item) error {
+func (c *MockClient) SetContext(ctx context.Context, item *item.Item) error {
 	args := Args{item}
-	key := MockupServer.getMockKey(OperationSet, args)
-	mock := MockupServer.mocks[key]
+	mock := c.server.call(ctx, c.timeout, OperationSet, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -78,10 +146,9 @@ func (c *clientMock) Set(item *item.Item) error {
 	return nil
 }
 
-func (c *clientMock) Add(item *item.Item) error {
+func (c *MockClient) AddContext(ctx context.Context, item *item.Item) error {
 	args := Args{item}
-	key := MockupServer.getMockKey(OperationAdd, args)
-	mock := MockupServer.mocks[key]
+	mock := c.server.call(ctx, c.timeout, OperationAdd, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -91,10 +158,9 @@ func (c *clientMock) Add(item *item.Item) error {
 	return nil
 }
 
-func (c *clientMock) Replace(item *item.Item) error {
+func (c *MockClient) ReplaceContext(ctx context.Context, item *item.Item) error {
 	args := Args{item}
-	key := MockupServer.getMockKey(OperationReplace, args)
-	mock := MockupServer.mocks[key]
+	mock := c.server.call(ctx, c.timeout, OperationReplace, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -104,10 +170,9 @@ func (c *clientMock) Replace(item *item.Item) error {
 	return nil
 }
 
-func (c *clientMock) CompareAndSwap(item *item.Item) error {
+func (c *MockClient) CompareAndSwapContext(ctx context.Context, item *item.Item) error {
 	args := Args{item}
-	key := MockupServer.getMockKey(OperationCompareAndSwap, args)
-	mock := MockupServer.mocks[key]
+	mock := c.server.call(ctx, c.timeout, OperationCompareAndSwap, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -117,10 +182,9 @@ func (c *clientMock) CompareAndSwap(item *item.Item) error {
 	return nil
 }
 
-func (c *clientMock) Delete(key string) error {
+func (c *MockClient) DeleteContext(ctx context.Context, key string) error {
 	args := Args{key}
-	mockKey := MockupServer.getMockKey(OperationDelete, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationDelete, args)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -130,9 +194,8 @@ func (c *clientMock) Delete(key string) error {
 	return nil
 }
 
-func (c *clientMock) DeleteAll() error {
-	key := MockupServer.getMockKey(OperationDeleteAll)
-	mock := MockupServer.mocks[key]
+func (c *MockClient) DeleteAllContext(ctx context.Context) error {
+	mock := c.server.call(ctx, c.timeout, OperationDeleteAll)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -142,9 +205,8 @@ func (c *clientMock) DeleteAll() error {
 	return nil
 }
 
-func (c *clientMock) Ping() error {
-	key := MockupServer.getMockKey(OperationPing)
-	mock := MockupServer.mocks[key]
+func (c *MockClient) PingContext(ctx context.Context) error {
+	mock := c.server.call(ctx, c.timeout, OperationPing)
 	if mock == nil {
 		return ErrMockNotFound
 	}
@@ -154,10 +216,9 @@ func (c *clientMock) Ping() error {
 	return nil
 }
 
-func (c *clientMock) Increment(key string, delta uint64) (newValue uint64, err error) {
+func (c *MockClient) IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
 	args := Args{key, delta}
-	mockKey := MockupServer.getMockKey(OperationIncrement, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationIncrement, args)
 	if mock == nil {
 		return 0, ErrMockNotFound
 	}
@@ -171,10 +232,9 @@ func (c *clientMock) Increment(key string, delta uint64) (newValue uint64, err e
 	return newValue, nil
 }
 
-func (c *clientMock) Decrement(key string, delta uint64) (newValue uint64, err error) {
+func (c *MockClient) DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
 	args := Args{key, delta}
-	mockKey := MockupServer.getMockKey(OperationDecrement, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationDecrement, args)
 	if mock == nil {
 		return 0, ErrMockNotFound
 	}
@@ -188,10 +248,9 @@ func (c *clientMock) Decrement(key string, delta uint64) (newValue uint64, err e
 	return newValue, nil
 }
 
-func (c *clientMock) Exists(key string) (bool, error) {
+func (c *MockClient) ExistsContext(ctx context.Context, key string) (bool, error) {
 	args := Args{key}
-	mockKey := MockupServer.getMockKey(OperationExists, args)
-	mock := MockupServer.mocks[mockKey]
+	mock := c.server.call(ctx, c.timeout, OperationExists, args)
 	if mock == nil {
 		return false, ErrMockNotFound
 	}
@@ -204,3 +263,105 @@ func (c *clientMock) Exists(key string) (bool, error) {
 	}
 	return exists, nil
 }
+
+// GetOrLoad returns the mocked value for the key if there is one.
+// Otherwise it behaves like a cache miss and returns the loader result.
+func (c *MockClient) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	args := Args{key}
+	mock := c.server.call(ctx, c.timeout, OperationGetOrLoad, args)
+	if mock == nil {
+		return loader()
+	}
+	if mock.Error != nil {
+		return nil, mock.Error
+	}
+	value, ok := mock.Return.([]byte)
+	if !ok {
+		return nil, ErrInterfaceConvertion
+	}
+	return value, nil
+}
+
+// MetaGet returns the mocked item for the key. The metadata only holds
+// the item CAS ID and size.
+func (c *MockClient) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	args := Args{key}
+	mock := c.server.call(ctx, c.timeout, OperationMetaGet, args)
+	if mock == nil {
+		return nil, nil, ErrMockNotFound
+	}
+	if mock.Error != nil {
+		return nil, nil, mock.Error
+	}
+	it, ok := mock.Return.(*item.Item)
+	if !ok {
+		return nil, nil, ErrInterfaceConvertion
+	}
+	return it, &item.Meta{CasID: it.CasID, Size: len(it.Value)}, nil
+}
+
+// MetaSet returns the mocked metadata, if any, for the item.
+func (c *MockClient) MetaSet(ctx context.Context, it *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	args := Args{it}
+	mock := c.server.call(ctx, c.timeout, OperationMetaSet, args)
+	if mock == nil {
+		return nil, ErrMockNotFound
+	}
+	if mock.Error != nil {
+		return nil, mock.Error
+	}
+	return mockMeta(mock.Return)
+}
+
+// MetaDelete returns the mocked metadata, if any, for the key.
+func (c *MockClient) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	args := Args{key}
+	mock := c.server.call(ctx, c.timeout, OperationMetaDelete, args)
+	if mock == nil {
+		return nil, ErrMockNotFound
+	}
+	if mock.Error != nil {
+		return nil, mock.Error
+	}
+	return mockMeta(mock.Return)
+}
+
+// MetaArithmetic returns the mocked value for the key.
+func (c *MockClient) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error) {
+	args := Args{key}
+	mock := c.server.call(ctx, c.timeout, OperationMetaArithmetic, args)
+	if mock == nil {
+		return 0, nil, ErrMockNotFound
+	}
+	if mock.Error != nil {
+		return 0, nil, mock.Error
+	}
+	newValue, ok := mock.Return.(uint64)
+	if !ok {
+		return 0, nil, ErrInterfaceConvertion
+	}
+	return newValue, &item.Meta{}, nil
+}
+
+func (c *MockClient) MetaNoop(ctx context.Context) error {
+	mock := c.server.call(ctx, c.timeout, OperationMetaNoop)
+	if mock == nil {
+		return ErrMockNotFound
+	}
+	if mock.Error != nil {
+		return mock.Error
+	}
+	return nil
+}
+
+// mockMeta converts an optional mock return value into metadata.
+func mockMeta(ret Return) (*item.Meta, error) {
+	if ret == nil {
+		return &item.Meta{}, nil
+	}
+	meta, ok := ret.(*item.Meta)
+	if !ok {
+		return nil, ErrInterfaceConvertion
+	}
+	return meta, nil
+}
@@ -0,0 +1,78 @@
+package memcachemock
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+var (
+	errorsMutex sync.RWMutex
+	errorNames  = []namedError{
+		{"ErrCacheMiss", memcache.ErrCacheMiss},
+		{"ErrCASConflict", memcache.ErrCASConflict},
+		{"ErrNotStored", memcache.ErrNotStored},
+		{"ErrServerError", memcache.ErrServerError},
+		{"ErrNoStats", memcache.ErrNoStats},
+		{"ErrMalformedKey", memcache.ErrMalformedKey},
+		{"ErrNoServers", memcache.ErrNoServers},
+		{"ErrMockNotFound", ErrMockNotFound},
+		{"ErrNonNumericValue", ErrNonNumericValue},
+		{"ErrInjectedFault", ErrInjectedFault},
+		{"ErrTimeout", ErrTimeout},
+		{"ErrConnReset", ErrConnReset},
+		{"Canceled", context.Canceled},
+		{"DeadlineExceeded", context.DeadlineExceeded},
+	}
+)
+
+type namedError struct {
+	name string
+	err  error
+}
+
+// RegisterError registers a sentinel error under a name, so it can be
+// referred to by name in cassettes and fixtures. The errors of the
+// memcache packages are registered already.
+func RegisterError(name string, err error) {
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+
+	for i := range errorNames {
+		if errorNames[i].name == name {
+			errorNames[i].err = err
+			return
+		}
+	}
+	errorNames = append(errorNames, namedError{name, err})
+}
+
+// ErrorName returns the name of the registered error err is, or false if
+// there is none.
+func ErrorName(err error) (string, bool) {
+	errorsMutex.RLock()
+	defer errorsMutex.RUnlock()
+
+	for _, e := range errorNames {
+		if errors.Is(err, e.err) {
+			return e.name, true
+		}
+	}
+	return "", false
+}
+
+// NamedError returns the error registered under name, or false if there
+// is none.
+func NamedError(name string) (error, bool) {
+	errorsMutex.RLock()
+	defer errorsMutex.RUnlock()
+
+	for _, e := range errorNames {
+		if e.name == name {
+			return e.err, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,177 @@
+package memcachemock
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// Call is an operation received by a mock server.
+type Call struct {
+	Operation Operation
+	Args      Args
+	// Mock is the mock that answered the call, or nil if there was none.
+	Mock *Mock
+	// Fault is the error injected into the call instead of answering it,
+	// if any.
+	Fault error
+}
+
+// Calls returns the calls received by the mock server, in order.
+func (m *MockServer) Calls() []Call {
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
+	return append([]Call(nil), m.calls...)
+}
+
+// Expect adds a mock for the operation and arguments and returns it, so
+// its response and the number of times it's expected to be called can be
+// set. Arguments can be Matchers, as in the arguments of the ExpectX
+// helpers.
+func (m *MockServer) Expect(op Operation, args ...interface{}) *Mock {
+	mock := &Mock{
+		Operation: op,
+		Args:      Args(args),
+	}
+	m.AddMock(mock)
+	return mock
+}
+
+// ExpectFlushAll expects a FlushAll call.
+func (m *MockServer) ExpectFlushAll() *Mock {
+	return m.Expect(OperationFlushAll)
+}
+
+// ExpectGet expects a Get call for the key.
+func (m *MockServer) ExpectGet(key interface{}) *Mock {
+	return m.Expect(OperationGet, key)
+}
+
+// ExpectTouch expects a Touch call for the key and seconds.
+func (m *MockServer) ExpectTouch(key, seconds interface{}) *Mock {
+	return m.Expect(OperationTouch, key, numberArg(seconds, int32(0)))
+}
+
+// ExpectGetMulti expects a GetMulti call for the keys.
+func (m *MockServer) ExpectGetMulti(keys interface{}) *Mock {
+	return m.Expect(OperationGetMulti, keys)
+}
+
+// ExpectSet expects a Set call for the item.
+func (m *MockServer) ExpectSet(it interface{}) *Mock {
+	return m.Expect(OperationSet, it)
+}
+
+// ExpectAdd expects an Add call for the item.
+func (m *MockServer) ExpectAdd(it interface{}) *Mock {
+	return m.Expect(OperationAdd, it)
+}
+
+// ExpectReplace expects a Replace call for the item.
+func (m *MockServer) ExpectReplace(it interface{}) *Mock {
+	return m.Expect(OperationReplace, it)
+}
+
+// ExpectCompareAndSwap expects a CompareAndSwap call for the item.
+func (m *MockServer) ExpectCompareAndSwap(it interface{}) *Mock {
+	return m.Expect(OperationCompareAndSwap, it)
+}
+
+// ExpectDelete expects a Delete call for the key.
+func (m *MockServer) ExpectDelete(key interface{}) *Mock {
+	return m.Expect(OperationDelete, key)
+}
+
+// ExpectDeleteAll expects a DeleteAll call.
+func (m *MockServer) ExpectDeleteAll() *Mock {
+	return m.Expect(OperationDeleteAll)
+}
+
+// ExpectPing expects a Ping call.
+func (m *MockServer) ExpectPing() *Mock {
+	return m.Expect(OperationPing)
+}
+
+// ExpectIncrement expects an Increment call for the key and delta.
+func (m *MockServer) ExpectIncrement(key, delta interface{}) *Mock {
+	return m.Expect(OperationIncrement, key, numberArg(delta, uint64(0)))
+}
+
+// ExpectDecrement expects a Decrement call for the key and delta.
+func (m *MockServer) ExpectDecrement(key, delta interface{}) *Mock {
+	return m.Expect(OperationDecrement, key, numberArg(delta, uint64(0)))
+}
+
+// ExpectExists expects an Exists call for the key.
+func (m *MockServer) ExpectExists(key interface{}) *Mock {
+	return m.Expect(OperationExists, key)
+}
+
+// numberArg converts a number to the type of the argument of an
+// operation, so untyped constants can be used.
+func numberArg(v interface{}, typ interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || rv.Kind() < reflect.Int || rv.Kind() > reflect.Float64 {
+		return v
+	}
+	return rv.Convert(reflect.TypeOf(typ)).Interface()
+}
+
+// AssertExpectations fails the test if any mock was never called, or
+// called fewer times than set with Times, or if the server received a
+// call no mock answered. It returns whether all expectations were met.
+func (m *MockServer) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+
+	var failures []string
+
+	m.serverMutex.Lock()
+	mocks := append([]*Mock(nil), m.matchers...)
+	for _, mock := range m.mocks {
+		mocks = append(mocks, mock)
+	}
+	for _, mock := range mocks {
+		used := m.used[mock]
+		switch {
+		case mock.times > 0 && used < mock.times:
+			failures = append(failures, fmt.Sprintf("%s was called %d times, expected %d", formatCall(mock.Operation, mock.Args), used, mock.times))
+		case used == 0:
+			failures = append(failures, fmt.Sprintf("%s was never called", formatCall(mock.Operation, mock.Args)))
+		}
+	}
+	sort.Strings(failures)
+	for _, call := range m.calls {
+		// A GetOrLoad without mock is a cache miss calling the loader.
+		if call.Mock == nil && call.Fault == nil && call.Operation != OperationGetOrLoad {
+			failures = append(failures, fmt.Sprintf("unexpected call %s", formatCall(call.Operation, call.Args)))
+		}
+	}
+	m.serverMutex.Unlock()
+
+	for _, failure := range failures {
+		t.Errorf("memcachemock: %s", failure)
+	}
+	return len(failures) == 0
+}
+
+// formatCall formats an operation and its arguments for messages, items
+// are identified by their key.
+func formatCall(op Operation, args Args) string {
+	parts := make([]string, 0, len(args))
+	for _, v := range args {
+		parts = append(parts, formatArg(v))
+	}
+	return fmt.Sprintf("%s(%s)", op, strings.Join(parts, ", "))
+}
+
+func formatArg(v interface{}) string {
+	if it, ok := v.(*item.Item); ok && it != nil {
+		v = it.Key
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,107 @@
+package memcachemock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// recordingT records the errors reported by AssertExpectations.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestExpectations(t *testing.T) {
+
+	t.Run("Met", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(&item.Item{Key: "key", Value: []byte("value")}).Times(2)
+		server.ExpectGet("key").WillReturn(&item.Item{Key: "key", Value: []byte("value")})
+
+		client := server.GetMockedClient()
+		client.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10})
+		client.Set(&item.Item{Key: "key", Value: []byte("value")})
+		if _, err := client.Get("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+
+		if !server.AssertExpectations(t) {
+			t.Errorf("Expected expectations to be met")
+		}
+	})
+
+	t.Run("Times", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectDelete("key").Times(1)
+
+		client := server.GetMockedClient()
+		if err := client.Delete("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := client.Delete("key"); !errors.Is(err, ErrMockNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrMockNotFound, err)
+		}
+	})
+
+	t.Run("Unmet", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(&item.Item{Key: "key", Value: []byte("value")}).Times(2)
+		server.ExpectPing()
+		server.ExpectExists("key").WillReturn(true)
+
+		client := server.GetMockedClient()
+		client.Set(&item.Item{Key: "key", Value: []byte("value")})
+		client.Exists("key")
+		client.Get("other")
+
+		rt := &recordingT{TB: t}
+		if server.AssertExpectations(rt) {
+			t.Errorf("Expected expectations not to be met")
+		}
+		expected := []string{
+			"memcachemock: Ping() was never called",
+			"memcachemock: Set(key) was called 1 times, expected 2",
+			"memcachemock: unexpected call Get(other)",
+		}
+		if len(rt.errors) != len(expected) {
+			t.Fatalf("Expected errors to be %v, got %v", expected, rt.errors)
+		}
+		for i, msg := range expected {
+			if rt.errors[i] != msg {
+				t.Errorf("Expected error to be %v, got %v", msg, rt.errors[i])
+			}
+		}
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(&item.Item{Key: "key", Value: []byte("value")})
+
+		client := server.GetMockedClient()
+		client.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10})
+		client.Ping()
+
+		calls := server.Calls()
+		if len(calls) != 2 {
+			t.Fatalf("Expected %v calls, got %v", 2, len(calls))
+		}
+		if calls[0].Operation != OperationSet || calls[0].Mock == nil {
+			t.Errorf("Expected first call to be a mocked %v, got %+v", OperationSet, calls[0])
+		}
+		if it := calls[0].Args[0].(*item.Item); it.Expiration != 10 {
+			t.Errorf("Expected recorded Expiration to be %v, got %v", 10, it.Expiration)
+		}
+		if calls[1].Operation != OperationPing || calls[1].Mock != nil {
+			t.Errorf("Expected second call to be an unmocked %v, got %+v", OperationPing, calls[1])
+		}
+	})
+}
@@ -0,0 +1,657 @@
+package memcachemock
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// maxRelativeExpiration is the largest expiration memcached interprets as
+// a number of seconds into the future. Larger values are Unix timestamps.
+const maxRelativeExpiration = 60 * 60 * 24 * 30
+
+// negativeFlag marks the items caching that a GetOrLoad loader reported
+// ErrNotFound, like memcache.GetOrLoad does.
+const negativeFlag uint32 = 1 << 31
+
+var ErrNonNumericValue = errors.New("memcache: client error: cannot increment or decrement non-numeric value")
+
+// FakeClient is an in-memory client that behaves like a memcached server:
+// it stores the items, honors their expiration against a clock the test
+// controls, assigns CAS IDs and returns the same errors as the real client.
+// Unlike the mockup server it needs no mocks, so a Set followed by a Get
+// just works.
+type FakeClient struct {
+	mu          sync.Mutex
+	items       map[string]*fakeItem
+	casID       uint64
+	now         time.Time
+	negativeTTL time.Duration
+}
+
+type fakeItem struct {
+	item      item.Item
+	expiresAt time.Time
+}
+
+// NewFakeClient creates an empty fake client whose clock starts at the
+// current time.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		items: make(map[string]*fakeItem),
+		now:   time.Now(),
+	}
+}
+
+// SetNegativeTTL caches for ttl the ErrNotFound reported by the GetOrLoad
+// loaders, like the builder option of the same name. A ttl of zero, the
+// default, doesn't cache them.
+func (f *FakeClient) SetNegativeTTL(ttl time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.negativeTTL = ttl
+}
+
+// Now returns the current time of the fake clock.
+func (f *FakeClient) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Advance moves the fake clock forward, expiring the items whose
+// expiration time is reached.
+func (f *FakeClient) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// SetNow sets the fake clock to the given time.
+func (f *FakeClient) SetNow(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = now
+}
+
+// Len returns the number of items that haven't expired.
+func (f *FakeClient) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for key := range f.items {
+		if f.lookup(key) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *FakeClient) FlushAll() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.items = make(map[string]*fakeItem)
+	return nil
+}
+
+func (f *FakeClient) Get(key string) (*item.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !legalKey(key) {
+		return nil, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		return nil, memcache.ErrCacheMiss
+	}
+	return copyItem(&fi.item), nil
+}
+
+func (f *FakeClient) Touch(key string, seconds int32) (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !legalKey(key) {
+		return memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		return memcache.ErrCacheMiss
+	}
+	fi.item.Expiration = seconds
+	fi.expiresAt = f.expiresAt(seconds)
+	return nil
+}
+
+func (f *FakeClient) GetMulti(keys []string) (map[string]*item.Item, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := make(map[string]*item.Item)
+	for _, key := range keys {
+		if !legalKey(key) {
+			return nil, memcache.ErrMalformedKey
+		}
+		if fi := f.lookup(key); fi != nil {
+			items[key] = copyItem(&fi.item)
+		}
+	}
+	return items, nil
+}
+
+func (f *FakeClient) Set(item *item.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.store(item)
+}
+
+func (f *FakeClient) Add(item *item.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if legalKey(item.Key) && f.lookup(item.Key) != nil {
+		return memcache.ErrNotStored
+	}
+	return f.store(item)
+}
+
+func (f *FakeClient) Replace(item *item.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if legalKey(item.Key) && f.lookup(item.Key) == nil {
+		return memcache.ErrNotStored
+	}
+	return f.store(item)
+}
+
+func (f *FakeClient) CompareAndSwap(item *item.Item) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !legalKey(item.Key) {
+		return memcache.ErrMalformedKey
+	}
+	fi := f.lookup(item.Key)
+	if fi == nil {
+		return memcache.ErrCacheMiss
+	}
+	if fi.item.CasID != item.CasID {
+		return memcache.ErrCASConflict
+	}
+	return f.store(item)
+}
+
+func (f *FakeClient) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !legalKey(key) {
+		return memcache.ErrMalformedKey
+	}
+	if f.lookup(key) == nil {
+		return memcache.ErrCacheMiss
+	}
+	delete(f.items, key)
+	return nil
+}
+
+func (f *FakeClient) DeleteAll() error {
+	return f.FlushAll()
+}
+
+func (f *FakeClient) Ping() error {
+	return nil
+}
+
+func (f *FakeClient) Increment(key string, delta uint64) (newValue uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.incrDecr(key, func(value uint64) uint64 {
+		return value + delta
+	})
+}
+
+func (f *FakeClient) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.incrDecr(key, func(value uint64) uint64 {
+		if delta > value {
+			return 0
+		}
+		return value - delta
+	})
+}
+
+func (f *FakeClient) Exists(key string) (bool, error) {
+	_, err := f.Get(key)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FakeClient) FlushAllContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.FlushAll()
+}
+
+func (f *FakeClient) GetContext(ctx context.Context, key string) (*item.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.Get(key)
+}
+
+func (f *FakeClient) TouchContext(ctx context.Context, key string, seconds int32) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Touch(key, seconds)
+}
+
+func (f *FakeClient) GetMultiContext(ctx context.Context, keys []string) (map[string]*item.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.GetMulti(keys)
+}
+
+func (f *FakeClient) SetContext(ctx context.Context, item *item.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Set(item)
+}
+
+func (f *FakeClient) AddContext(ctx context.Context, item *item.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Add(item)
+}
+
+func (f *FakeClient) ReplaceContext(ctx context.Context, item *item.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Replace(item)
+}
+
+func (f *FakeClient) CompareAndSwapContext(ctx context.Context, item *item.Item) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.CompareAndSwap(item)
+}
+
+func (f *FakeClient) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Delete(key)
+}
+
+func (f *FakeClient) DeleteAllContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.DeleteAll()
+}
+
+func (f *FakeClient) PingContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.Ping()
+}
+
+func (f *FakeClient) IncrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.Increment(key, delta)
+}
+
+func (f *FakeClient) DecrementContext(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.Decrement(key, delta)
+}
+
+func (f *FakeClient) ExistsContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return f.Exists(key)
+}
+
+// GetOrLoad gets the value for the given key, or stores and returns the
+// loader result on a miss. The ErrNotFound of the loader is cached for the
+// negative TTL, if any.
+func (f *FakeClient) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() ([]byte, error)) ([]byte, error) {
+	it, err := f.GetContext(ctx, key)
+	if err == nil {
+		if it.Flags&negativeFlag != 0 {
+			notFound, _ := NamedError("ErrNotFound")
+			return nil, notFound
+		}
+		return it.Value, nil
+	}
+	if !errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, err
+	}
+
+	value, err := loader()
+	if name, _ := ErrorName(err); name == "ErrNotFound" {
+		f.mu.Lock()
+		negativeTTL := f.negativeTTL
+		f.mu.Unlock()
+		if negativeTTL > 0 {
+			f.Set(&item.Item{Key: key, Flags: negativeFlag, Expiration: f.expiration(negativeTTL)})
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Set(&item.Item{Key: key, Value: value, Expiration: f.expiration(ttl)}); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// expiration returns the expiration of an item stored for ttl, rounding
+// the TTLs under a second up to a second.
+func (f *FakeClient) expiration(ttl time.Duration) int32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > maxRelativeExpiration*time.Second:
+		return int32(f.Now().Add(ttl).Unix())
+	case ttl < time.Second:
+		return 1
+	}
+	return int32(ttl / time.Second)
+}
+
+// MetaGet gets the item and its CAS ID, remaining TTL and size. Leases
+// (VivifyTTL and RecacheTTL) are not supported.
+func (f *FakeClient) MetaGet(ctx context.Context, key string, opts *item.MetaGetOptions) (*item.Item, *item.Meta, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if opts == nil {
+		opts = &item.MetaGetOptions{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !opts.Base64Key && !legalKey(key) {
+		return nil, nil, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		return nil, nil, memcache.ErrCacheMiss
+	}
+	if opts.Touch {
+		fi.item.Expiration = opts.TouchTTL
+		fi.expiresAt = f.expiresAt(opts.TouchTTL)
+	}
+	return copyItem(&fi.item), f.meta(fi, opts.Opaque), nil
+}
+
+// MetaSet stores the item according to the mode and CAS ID in opts.
+func (f *FakeClient) MetaSet(ctx context.Context, it *item.Item, opts *item.MetaSetOptions) (*item.Meta, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &item.MetaSetOptions{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !opts.Base64Key && !legalKey(it.Key) {
+		return nil, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(it.Key)
+	if opts.CompareCasID != 0 {
+		if fi == nil {
+			return nil, memcache.ErrCacheMiss
+		}
+		if fi.item.CasID != opts.CompareCasID {
+			return nil, memcache.ErrCASConflict
+		}
+	}
+
+	stored := copyItem(it)
+	switch opts.Mode {
+	case item.MetaSetModeAdd:
+		if fi != nil {
+			return nil, memcache.ErrNotStored
+		}
+	case item.MetaSetModeReplace:
+		if fi == nil {
+			return nil, memcache.ErrNotStored
+		}
+	case item.MetaSetModeAppend, item.MetaSetModePrepend:
+		if fi == nil {
+			return nil, memcache.ErrNotStored
+		}
+		stored = copyItem(&fi.item)
+		if opts.Mode == item.MetaSetModeAppend {
+			stored.Value = append(stored.Value, it.Value...)
+		} else {
+			stored.Value = append(append([]byte{}, it.Value...), stored.Value...)
+		}
+	}
+
+	f.storeItem(stored)
+	it.CasID = stored.CasID
+	return f.meta(f.items[it.Key], opts.Opaque), nil
+}
+
+// MetaDelete deletes the item, honoring the CAS ID in opts.
+func (f *FakeClient) MetaDelete(ctx context.Context, key string, opts *item.MetaDeleteOptions) (*item.Meta, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &item.MetaDeleteOptions{}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !opts.Base64Key && !legalKey(key) {
+		return nil, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		return nil, memcache.ErrCacheMiss
+	}
+	if opts.CompareCasID != 0 && fi.item.CasID != opts.CompareCasID {
+		return nil, memcache.ErrCASConflict
+	}
+	delete(f.items, key)
+	return &item.Meta{Opaque: opts.Opaque}, nil
+}
+
+// MetaArithmetic increments or decrements the value by opts.Delta, or by
+// 1 if it is zero like with the meta protocol, creating it when
+// opts.VivifyTTL is set and honoring the CAS ID in opts. Increment and
+// Decrement use their delta as it is.
+func (f *FakeClient) MetaArithmetic(ctx context.Context, key string, opts *item.MetaArithmeticOptions) (newValue uint64, meta *item.Meta, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	if opts == nil {
+		opts = &item.MetaArithmeticOptions{}
+	}
+	delta := opts.Delta
+	if delta == 0 {
+		delta = 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !opts.Base64Key && !legalKey(key) {
+		return 0, nil, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		if opts.VivifyTTL == 0 {
+			return 0, nil, memcache.ErrCacheMiss
+		}
+		f.storeItem(&item.Item{
+			Key:        key,
+			Value:      []byte(strconv.FormatUint(opts.InitialValue, 10)),
+			Expiration: opts.VivifyTTL,
+		})
+		return opts.InitialValue, f.meta(f.items[key], opts.Opaque), nil
+	}
+	if opts.CompareCasID != 0 && fi.item.CasID != opts.CompareCasID {
+		return 0, nil, memcache.ErrCASConflict
+	}
+
+	newValue, err = f.incrDecr(key, func(value uint64) uint64 {
+		if opts.Mode == item.MetaArithmeticDecrement {
+			if delta > value {
+				return 0
+			}
+			return value - delta
+		}
+		return value + delta
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return newValue, f.meta(f.items[key], opts.Opaque), nil
+}
+
+func (f *FakeClient) MetaNoop(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// lookup returns the live item for key, dropping it if it expired.
+func (f *FakeClient) lookup(key string) *fakeItem {
+	fi, ok := f.items[key]
+	if !ok {
+		return nil
+	}
+	if !fi.expiresAt.IsZero() && !f.now.Before(fi.expiresAt) {
+		delete(f.items, key)
+		return nil
+	}
+	return fi
+}
+
+func (f *FakeClient) store(it *item.Item) error {
+	if !legalKey(it.Key) {
+		return memcache.ErrMalformedKey
+	}
+	stored := copyItem(it)
+	f.storeItem(stored)
+	it.CasID = stored.CasID
+	return nil
+}
+
+// storeItem stores it with a new CAS ID.
+func (f *FakeClient) storeItem(it *item.Item) {
+	f.casID++
+	it.CasID = f.casID
+	f.items[it.Key] = &fakeItem{
+		item:      *it,
+		expiresAt: f.expiresAt(it.Expiration),
+	}
+}
+
+func (f *FakeClient) incrDecr(key string, op func(uint64) uint64) (uint64, error) {
+	if !legalKey(key) {
+		return 0, memcache.ErrMalformedKey
+	}
+	fi := f.lookup(key)
+	if fi == nil {
+		return 0, memcache.ErrCacheMiss
+	}
+	value, err := strconv.ParseUint(string(fi.item.Value), 10, 64)
+	if err != nil {
+		return 0, ErrNonNumericValue
+	}
+	value = op(value)
+	f.casID++
+	fi.item.CasID = f.casID
+	fi.item.Value = []byte(strconv.FormatUint(value, 10))
+	return value, nil
+}
+
+// expiresAt converts an item expiration to an absolute time. The zero time
+// means the item never expires.
+func (f *FakeClient) expiresAt(expiration int32) time.Time {
+	switch {
+	case expiration == 0:
+		return time.Time{}
+	case expiration < 0:
+		return f.now
+	case expiration <= maxRelativeExpiration:
+		return f.now.Add(time.Duration(expiration) * time.Second)
+	}
+	return time.Unix(int64(expiration), 0)
+}
+
+func (f *FakeClient) meta(fi *fakeItem, opaque string) *item.Meta {
+	ttl := int32(-1)
+	if !fi.expiresAt.IsZero() {
+		ttl = int32(fi.expiresAt.Sub(f.now) / time.Second)
+	}
+	return &item.Meta{
+		CasID:  fi.item.CasID,
+		TTL:    ttl,
+		Size:   len(fi.item.Value),
+		Opaque: opaque,
+	}
+}
+
+func copyItem(it *item.Item) *item.Item {
+	c := *it
+	c.Value = append([]byte(nil), it.Value...)
+	return &c
+}
+
+// legalKey reports whether key is accepted by memcached.
+func legalKey(key string) bool {
+	if len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
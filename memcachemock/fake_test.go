@@ -0,0 +1,159 @@
+package memcachemock
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+func TestFakeClient(t *testing.T) {
+
+	t.Run("SetGet", func(t *testing.T) {
+		fake := NewFakeClient()
+		if err := fake.Set(&item.Item{Key: "key", Value: []byte("value")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := fake.Get("key")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "value" {
+			t.Errorf("Expected value to be %v, got %v", "value", string(it.Value))
+		}
+	})
+
+	t.Run("GetMiss", func(t *testing.T) {
+		fake := NewFakeClient()
+		if _, err := fake.Get("key"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("MalformedKey", func(t *testing.T) {
+		fake := NewFakeClient()
+		if err := fake.Set(&item.Item{Key: "my key"}); !errors.Is(err, memcache.ErrMalformedKey) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrMalformedKey, err)
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10})
+
+		fake.Advance(time.Second * 9)
+		if _, err := fake.Get("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		fake.Advance(time.Second)
+		if _, err := fake.Get("key"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10})
+		fake.Touch("key", 100)
+
+		fake.Advance(time.Second * 50)
+		if _, err := fake.Get("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("AddReplace", func(t *testing.T) {
+		fake := NewFakeClient()
+		if err := fake.Replace(&item.Item{Key: "key"}); !errors.Is(err, memcache.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrNotStored, err)
+		}
+		if err := fake.Add(&item.Item{Key: "key"}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := fake.Add(&item.Item{Key: "key"}); !errors.Is(err, memcache.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrNotStored, err)
+		}
+		if err := fake.Replace(&item.Item{Key: "key"}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("a")})
+
+		first, _ := fake.Get("key")
+		second, _ := fake.Get("key")
+
+		first.Value = []byte("b")
+		if err := fake.CompareAndSwap(first); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		second.Value = []byte("c")
+		if err := fake.CompareAndSwap(second); !errors.Is(err, memcache.ErrCASConflict) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCASConflict, err)
+		}
+	})
+
+	t.Run("IncrementWrapsAround", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte(strconv.FormatUint(math.MaxUint64, 10))})
+
+		value, err := fake.Increment("key", 2)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if value != 1 {
+			t.Errorf("Expected value to be %v, got %v", 1, value)
+		}
+	})
+
+	t.Run("IncrementByZero", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("7")})
+
+		value, err := fake.Increment("key", 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if value != 7 {
+			t.Errorf("Expected value to be %v, got %v", 7, value)
+		}
+	})
+
+	t.Run("DecrementCapsAtZero", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("3")})
+
+		value, err := fake.Decrement("key", 5)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if value != 0 {
+			t.Errorf("Expected value to be %v, got %v", 0, value)
+		}
+	})
+
+	t.Run("IncrementNonNumeric", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key", Value: []byte("value")})
+
+		if _, err := fake.Increment("key", 1); !errors.Is(err, ErrNonNumericValue) {
+			t.Errorf("Expected error to be %v, got %v", ErrNonNumericValue, err)
+		}
+	})
+
+	t.Run("FlushAll", func(t *testing.T) {
+		fake := NewFakeClient()
+		fake.Set(&item.Item{Key: "key"})
+		fake.FlushAll()
+
+		if exists, _ := fake.Exists("key"); exists {
+			t.Errorf("Expected key to be flushed")
+		}
+	})
+}
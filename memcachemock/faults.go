@@ -0,0 +1,159 @@
+package memcachemock
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrInjectedFault is the error of failing calls of faults without
+	// Error.
+	ErrInjectedFault = errors.New("memcachemock: injected fault")
+
+	// ErrTimeout is returned by calls delayed longer than the timeout of
+	// the mock server, like the client does when a socket times out.
+	ErrTimeout error = &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	// ErrConnReset is returned by calls failing with a connection reset.
+	ErrConnReset error = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+)
+
+// Fault describes latency and failures injected into operations. Rates
+// are probabilities from 0 to 1.
+type Fault struct {
+	// Operations the fault applies to, all of them if empty.
+	Operations []Operation
+
+	// Delay is added to every call.
+	Delay time.Duration
+	// Jitter adds a random delay up to it to every call.
+	Jitter time.Duration
+
+	// ErrorRate is the rate of calls failing with Error.
+	ErrorRate float64
+	// Error is the error of failing calls, ErrInjectedFault if nil.
+	Error error
+
+	// ResetRate is the rate of calls failing with a connection reset.
+	ResetRate float64
+
+	// PartialRate is the rate of keys missing from the result of GetMulti
+	// calls. The calls that miss keys fail with Error too, like when one
+	// of several servers fails.
+	PartialRate float64
+}
+
+func (f *Fault) appliesTo(op Operation) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, o := range f.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fault) err() error {
+	if f.Error != nil {
+		return f.Error
+	}
+	return ErrInjectedFault
+}
+
+// Injection is the latency and failure drawn for a call.
+type Injection struct {
+	Delay time.Duration
+	// Reset reports whether the connection must be reset.
+	Reset bool
+	Err   error
+	// Dropped holds the keys of a GetMulti call missing from its result.
+	// The call returns the other keys along with Err.
+	Dropped map[string]bool
+}
+
+// Faults injects latency and failures into calls. Random failures are
+// drawn from a source seeded with SetSeed, 0 by default, so a test
+// making the same calls fails the same way on every run.
+type Faults struct {
+	faultsMutex sync.Mutex
+	faults      []Fault
+	rand        *rand.Rand
+}
+
+// InjectFault adds a fault. The delays of all the faults applying to a
+// call add up, and the first failure drawn wins.
+func (f *Faults) InjectFault(fault Fault) {
+	f.faultsMutex.Lock()
+	defer f.faultsMutex.Unlock()
+
+	f.faults = append(f.faults, fault)
+}
+
+// ClearFaults removes all faults.
+func (f *Faults) ClearFaults() {
+	f.faultsMutex.Lock()
+	defer f.faultsMutex.Unlock()
+
+	f.faults = nil
+}
+
+// SetSeed seeds the source of random failures.
+func (f *Faults) SetSeed(seed int64) {
+	f.faultsMutex.Lock()
+	defer f.faultsMutex.Unlock()
+
+	f.rand = rand.New(rand.NewSource(seed))
+}
+
+// Draw draws the injection for a call to the operation. keys are the keys
+// of GetMulti calls.
+func (f *Faults) Draw(op Operation, keys []string) Injection {
+	f.faultsMutex.Lock()
+	defer f.faultsMutex.Unlock()
+
+	var inj Injection
+	for i := range f.faults {
+		fault := &f.faults[i]
+		if !fault.appliesTo(op) {
+			continue
+		}
+		if f.rand == nil {
+			f.rand = rand.New(rand.NewSource(0))
+		}
+
+		inj.Delay += fault.Delay
+		if fault.Jitter > 0 {
+			inj.Delay += time.Duration(f.rand.Int63n(int64(fault.Jitter)))
+		}
+		if inj.Err != nil {
+			continue
+		}
+		switch {
+		case fault.ResetRate > 0 && f.rand.Float64() < fault.ResetRate:
+			inj.Reset = true
+			inj.Err = ErrConnReset
+		case fault.ErrorRate > 0 && f.rand.Float64() < fault.ErrorRate:
+			inj.Err = fault.err()
+		case op == OperationGetMulti && fault.PartialRate > 0:
+			for _, key := range keys {
+				if f.rand.Float64() < fault.PartialRate {
+					if inj.Dropped == nil {
+						inj.Dropped = make(map[string]bool)
+					}
+					inj.Dropped[key] = true
+				}
+			}
+			if len(inj.Dropped) > 0 {
+				inj.Err = fault.err()
+			}
+		}
+	}
+	return inj
+}
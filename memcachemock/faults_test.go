@@ -0,0 +1,146 @@
+package memcachemock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+func TestFaults(t *testing.T) {
+
+	t.Run("Delay", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectPing()
+		server.InjectFault(Fault{Delay: time.Millisecond * 20})
+
+		start := time.Now()
+		if err := server.GetMockedClient().Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*20 {
+			t.Errorf("Expected call to be delayed by %v, got %v", time.Millisecond*20, elapsed)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectPing()
+		server.SetTimeout(time.Millisecond * 10)
+		server.InjectFault(Fault{Delay: time.Second})
+
+		start := time.Now()
+		if err := server.GetMockedClient().Ping(); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected error to be %v, got %v", ErrTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+			t.Errorf("Expected call to time out after %v, got %v", time.Millisecond*10, elapsed)
+		}
+		if calls := server.Calls(); len(calls) != 1 || !errors.Is(calls[0].Fault, ErrTimeout) {
+			t.Errorf("Expected the call to be recorded with its fault, got %+v", calls)
+		}
+	})
+
+	t.Run("ClientTimeout", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectPing()
+		server.SetTimeout(time.Second * 5)
+		server.InjectFault(Fault{Delay: time.Second})
+
+		start := time.Now()
+		if err := server.GetMockedClient().WithTimeout(time.Millisecond * 10).Ping(); !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected error to be %v, got %v", ErrTimeout, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+			t.Errorf("Expected call to time out after %v, got %v", time.Millisecond*10, elapsed)
+		}
+	})
+
+	t.Run("ContextDeadline", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.InjectFault(Fault{Delay: time.Millisecond * 200})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		if _, err := server.GetMockedClient().GetContext(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected error to be %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("ErrorRate", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectGet("key").WillReturn(&item.Item{Key: "key"})
+		server.ExpectDelete("key")
+		server.InjectFault(Fault{Operations: []Operation{OperationGet}, ErrorRate: 1})
+
+		client := server.GetMockedClient()
+		if _, err := client.Get("key"); !errors.Is(err, ErrInjectedFault) {
+			t.Errorf("Expected error to be %v, got %v", ErrInjectedFault, err)
+		}
+		if err := client.Delete("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.InjectFault(Fault{ResetRate: 1})
+
+		if err := server.GetMockedClient().Set(&item.Item{Key: "key"}); !errors.Is(err, ErrConnReset) {
+			t.Errorf("Expected error to be %v, got %v", ErrConnReset, err)
+		}
+	})
+
+	t.Run("PartialGetMulti", func(t *testing.T) {
+		server := NewMockServer(t)
+		keys := []string{"a", "b", "c", "d"}
+		items := map[string]*item.Item{}
+		for _, key := range keys {
+			items[key] = &item.Item{Key: key}
+		}
+		server.ExpectGetMulti(keys).WillReturn(items)
+		server.InjectFault(Fault{PartialRate: 0.5})
+
+		got, err := server.GetMockedClient().GetMulti(keys)
+		if !errors.Is(err, ErrInjectedFault) {
+			t.Errorf("Expected error to be %v, got %v", ErrInjectedFault, err)
+		}
+		if len(got) == 0 || len(got) == len(keys) {
+			t.Errorf("Expected some items to be returned, got %v", got)
+		}
+	})
+
+	t.Run("Seed", func(t *testing.T) {
+		draw := func(seed int64) []bool {
+			server := NewMockServer(t)
+			server.SetSeed(seed)
+			server.InjectFault(Fault{ErrorRate: 0.5})
+
+			var failed []bool
+			for i := 0; i < 20; i++ {
+				_, err := server.GetMockedClient().Get(fmt.Sprint(i))
+				failed = append(failed, errors.Is(err, ErrInjectedFault))
+			}
+			return failed
+		}
+
+		if first, second := draw(42), draw(42); !reflect.DeepEqual(first, second) {
+			t.Errorf("Expected the same failures with the same seed, got %v and %v", first, second)
+		}
+	})
+
+	t.Run("DeleteMocksClearsFaults", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.InjectFault(Fault{ErrorRate: 1})
+		server.DeleteMocks()
+		server.ExpectPing()
+
+		if err := server.GetMockedClient().Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
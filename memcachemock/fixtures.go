@@ -0,0 +1,329 @@
+package memcachemock
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/getmiranda/gomemcached/item"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidFixture = errors.New("invalid fixture")
+
+// fixtureKind is the kind of an argument or return value of an
+// operation.
+type fixtureKind int
+
+const (
+	kindNone fixtureKind = iota
+	kindString
+	kindStrings
+	kindInt32
+	kindUint64
+	kindBool
+	kindItem
+	kindItems
+	kindValue
+	kindMeta
+)
+
+var kindNames = map[fixtureKind]string{
+	kindNone:    "nothing",
+	kindString:  "a string",
+	kindStrings: "a list of strings",
+	kindInt32:   "an int32",
+	kindUint64:  "a uint64",
+	kindBool:    "a bool",
+	kindItem:    "an item",
+	kindItems:   "a mapping of keys to items",
+	kindValue:   "a value",
+	kindMeta:    "metadata",
+}
+
+// operationSpec describes the arguments and return value MockClient
+// expects for an operation.
+type operationSpec struct {
+	args []fixtureKind
+	ret  fixtureKind
+}
+
+var operationSpecs = map[Operation]operationSpec{
+	OperationFlushAll:       {},
+	OperationGet:            {args: []fixtureKind{kindString}, ret: kindItem},
+	OperationGetMulti:       {args: []fixtureKind{kindStrings}, ret: kindItems},
+	OperationSet:            {args: []fixtureKind{kindItem}},
+	OperationAdd:            {args: []fixtureKind{kindItem}},
+	OperationReplace:        {args: []fixtureKind{kindItem}},
+	OperationCompareAndSwap: {args: []fixtureKind{kindItem}},
+	OperationDelete:         {args: []fixtureKind{kindString}},
+	OperationIncrement:      {args: []fixtureKind{kindString, kindUint64}, ret: kindUint64},
+	OperationDecrement:      {args: []fixtureKind{kindString, kindUint64}, ret: kindUint64},
+	OperationExists:         {args: []fixtureKind{kindString}, ret: kindBool},
+	OperationTouch:          {args: []fixtureKind{kindString, kindInt32}},
+	OperationDeleteAll:      {},
+	OperationPing:           {},
+	OperationGetOrLoad:      {args: []fixtureKind{kindString}, ret: kindValue},
+	OperationMetaGet:        {args: []fixtureKind{kindString}, ret: kindItem},
+	OperationMetaSet:        {args: []fixtureKind{kindItem}, ret: kindMeta},
+	OperationMetaDelete:     {args: []fixtureKind{kindString}, ret: kindMeta},
+	OperationMetaArithmetic: {args: []fixtureKind{kindString}, ret: kindUint64},
+	OperationMetaNoop:       {},
+}
+
+// fixtures is the content of a fixtures file.
+type fixtures struct {
+	Mocks []yaml.Node `yaml:"mocks"`
+}
+
+// fixture is a mock in a fixtures file.
+type fixture struct {
+	Operation string      `yaml:"operation"`
+	Args      []yaml.Node `yaml:"args"`
+	Return    yaml.Node   `yaml:"return"`
+	Error     string      `yaml:"error"`
+	Responses []struct {
+		Return yaml.Node `yaml:"return"`
+		Error  string    `yaml:"error"`
+	} `yaml:"responses"`
+	Times int `yaml:"times"`
+}
+
+// fixtureValue is a value given either as a string or encoded in base64.
+type fixtureValue struct {
+	Value       *string `yaml:"value"`
+	ValueBase64 *string `yaml:"value_base64"`
+}
+
+func (v *fixtureValue) bytes() ([]byte, error) {
+	switch {
+	case v.Value != nil && v.ValueBase64 != nil:
+		return nil, errors.New("value and value_base64 are exclusive")
+	case v.ValueBase64 != nil:
+		return base64.StdEncoding.DecodeString(*v.ValueBase64)
+	case v.Value != nil:
+		return []byte(*v.Value), nil
+	}
+	return nil, nil
+}
+
+// fixtureItem is an item in a fixtures file.
+type fixtureItem struct {
+	Key          string `yaml:"key"`
+	fixtureValue `yaml:",inline"`
+	Flags        uint32 `yaml:"flags"`
+	Expiration   int32  `yaml:"expiration"`
+	CasID        uint64 `yaml:"cas"`
+}
+
+func (f *fixtureItem) item() (*item.Item, error) {
+	value, err := f.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &item.Item{
+		Key:        f.Key,
+		Value:      value,
+		Flags:      f.Flags,
+		Expiration: f.Expiration,
+		CasID:      f.CasID,
+	}, nil
+}
+
+// LoadFixtures adds the mocks described by a YAML or JSON fixtures file.
+// See ReadFixtures for its format.
+func (m *MockServer) LoadFixtures(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := m.ReadFixtures(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadFixtures adds the mocks described by YAML or JSON fixtures:
+//
+//	mocks:
+//	  - operation: Get
+//	    args: [mykey]
+//	    return: {key: mykey, value: myvalue, flags: 0, expiration: 0, cas: 0}
+//	  - operation: Get
+//	    args: [other]
+//	    error: ErrCacheMiss
+//	  - operation: Increment
+//	    args: [counter, 1]
+//	    responses:
+//	      - return: 1
+//	      - error: ErrServerError
+//	    times: 2
+//
+// The arguments and return value of a mock are the ones of the mocks of
+// its operation. Values of items are strings, or base64 with
+// value_base64. Errors are names registered with RegisterError. No mock
+// is added if any is invalid.
+func (m *MockServer) ReadFixtures(r io.Reader) error {
+	var f fixtures
+	if err := yaml.NewDecoder(r).Decode(&f); err != nil && err != io.EOF {
+		return err
+	}
+
+	mocks := make([]*Mock, 0, len(f.Mocks))
+	for i := range f.Mocks {
+		node := &f.Mocks[i]
+		mock, err := parseFixture(node)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrInvalidFixture, node.Line, err)
+		}
+		mocks = append(mocks, mock)
+	}
+	for _, mock := range mocks {
+		m.AddMock(mock)
+	}
+	return nil
+}
+
+func parseFixture(node *yaml.Node) (*Mock, error) {
+	var f fixture
+	if err := node.Decode(&f); err != nil {
+		return nil, err
+	}
+	op := Operation(f.Operation)
+	spec, ok := operationSpecs[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", f.Operation)
+	}
+	if len(f.Args) != len(spec.args) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", op, len(spec.args), len(f.Args))
+	}
+
+	mock := &Mock{Operation: op, times: f.Times}
+	for i := range f.Args {
+		arg, err := decodeFixture(&f.Args[i], spec.args[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s argument %d: %v", op, i+1, err)
+		}
+		mock.Args = append(mock.Args, arg)
+	}
+
+	var err error
+	if mock.Return, mock.Error, err = parseResponse(op, spec, &f.Return, f.Error); err != nil {
+		return nil, err
+	}
+	for _, resp := range f.Responses {
+		ret, respErr, err := parseResponse(op, spec, &resp.Return, resp.Error)
+		if err != nil {
+			return nil, err
+		}
+		mock.Responses = append(mock.Responses, Response{Return: ret, Error: respErr})
+	}
+	return mock, nil
+}
+
+func parseResponse(op Operation, spec operationSpec, node *yaml.Node, errName string) (Return, error, error) {
+	var respErr error
+	if errName != "" {
+		var ok bool
+		if respErr, ok = NamedError(errName); !ok {
+			return nil, nil, fmt.Errorf("unknown error %q", errName)
+		}
+	}
+	if node.Kind == 0 {
+		return nil, respErr, nil
+	}
+	if spec.ret == kindNone {
+		return nil, nil, fmt.Errorf("%s doesn't return a value", op)
+	}
+	ret, err := decodeFixture(node, spec.ret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s return: %v", op, err)
+	}
+	return ret, respErr, nil
+}
+
+// decodeFixture decodes a value of the kind into the type MockClient
+// expects.
+func decodeFixture(node *yaml.Node, kind fixtureKind) (interface{}, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch kind {
+	case kindString:
+		var s string
+		err = decodeNode(node, yaml.ScalarNode, &s)
+		v = s
+	case kindStrings:
+		var s []string
+		err = decodeNode(node, yaml.SequenceNode, &s)
+		v = s
+	case kindInt32:
+		var n int32
+		err = decodeNode(node, yaml.ScalarNode, &n)
+		v = n
+	case kindUint64:
+		var n uint64
+		err = decodeNode(node, yaml.ScalarNode, &n)
+		v = n
+	case kindBool:
+		var b bool
+		err = decodeNode(node, yaml.ScalarNode, &b)
+		v = b
+	case kindItem:
+		var f fixtureItem
+		if err = decodeNode(node, yaml.MappingNode, &f); err == nil {
+			v, err = f.item()
+		}
+	case kindItems:
+		var f map[string]fixtureItem
+		if err = decodeNode(node, yaml.MappingNode, &f); err == nil {
+			items := make(map[string]*item.Item, len(f))
+			for key, fi := range f {
+				if fi.Key == "" {
+					fi.Key = key
+				}
+				if items[key], err = fi.item(); err != nil {
+					break
+				}
+			}
+			v = items
+		}
+	case kindValue:
+		var f fixtureValue
+		if err = decodeNode(node, yaml.MappingNode, &f); err == nil {
+			v, err = f.bytes()
+		}
+	case kindMeta:
+		meta := new(item.Meta)
+		err = decodeNode(node, yaml.MappingNode, meta)
+		v = meta
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expected %s: %v", kindNames[kind], err)
+	}
+	return v, nil
+}
+
+func decodeNode(node *yaml.Node, kind yaml.Kind, v interface{}) error {
+	if node.Kind != kind {
+		return fmt.Errorf("line %d: unexpected %s", node.Line, nodeKindName(node.Kind))
+	}
+	return node.Decode(v)
+}
+
+func nodeKindName(kind yaml.Kind) string {
+	switch kind {
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		return "scalar"
+	}
+	return "value"
+}
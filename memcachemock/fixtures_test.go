@@ -0,0 +1,106 @@
+package memcachemock
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestFixtures(t *testing.T) {
+
+	t.Run("YAML", func(t *testing.T) {
+		server := NewMockServer(t)
+		err := server.ReadFixtures(strings.NewReader(`
+mocks:
+  - operation: Get
+    args: [mykey]
+    return: {key: mykey, value: myvalue, flags: 2}
+  - operation: Get
+    args: [binary]
+    return: {key: binary, value_base64: AAE=}
+  - operation: Get
+    args: [missing]
+    error: ErrCacheMiss
+  - operation: GetMulti
+    args: [[a, b]]
+    return:
+      a: {value: "1"}
+  - operation: Increment
+    args: [counter, 1]
+    responses:
+      - return: 1
+      - error: ErrServerError
+`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		client := server.GetMockedClient()
+		if it, err := client.Get("mykey"); err != nil || string(it.Value) != "myvalue" || it.Flags != 2 {
+			t.Errorf("Expected item to be %v, got %v (%v)", "mykey=myvalue", it, err)
+		}
+		if it, err := client.Get("binary"); err != nil || string(it.Value) != "\x00\x01" {
+			t.Errorf("Expected value to be %v, got %v (%v)", []byte{0, 1}, it, err)
+		}
+		if _, err := client.Get("missing"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		if items, err := client.GetMulti([]string{"a", "b"}); err != nil || items["a"].Key != "a" {
+			t.Errorf("Expected item a to be returned, got %v (%v)", items, err)
+		}
+		if value, err := client.Increment("counter", 1); err != nil || value != 1 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 1, value, err)
+		}
+		if _, err := client.Increment("counter", 1); !errors.Is(err, memcache.ErrServerError) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrServerError, err)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		server := NewMockServer(t)
+		err := server.ReadFixtures(strings.NewReader(`{"mocks": [
+			{"operation": "Exists", "args": ["mykey"], "return": true},
+			{"operation": "Touch", "args": ["mykey", 10]}
+		]}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		client := server.GetMockedClient()
+		if exists, err := client.Exists("mykey"); err != nil || !exists {
+			t.Errorf("Expected key to exist, got %v (%v)", exists, err)
+		}
+		if err := client.Touch("mykey", 10); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			fixtures string
+			expected string
+		}{
+			{"UnknownOperation", `{mocks: [{operation: Gett, args: [k]}]}`, `unknown operation "Gett"`},
+			{"ArgumentCount", `{mocks: [{operation: Get, args: [k, v]}]}`, "Get takes 1 arguments, got 2"},
+			{"ArgumentType", `{mocks: [{operation: Set, args: [k]}]}`, "Set argument 1: expected an item"},
+			{"ReturnType", `{mocks: [{operation: Get, args: [k], return: value}]}`, "Get return: expected an item"},
+			{"NoReturn", `{mocks: [{operation: Delete, args: [k], return: true}]}`, "Delete doesn't return a value"},
+			{"UnknownError", `{mocks: [{operation: Delete, args: [k], error: ErrBoom}]}`, `unknown error "ErrBoom"`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := NewMockServer(t)
+				err := server.ReadFixtures(strings.NewReader(tt.fixtures))
+				if !errors.Is(err, ErrInvalidFixture) || !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("Expected error to contain %q, got %v", tt.expected, err)
+				}
+				if len(server.mocks) != 0 {
+					t.Errorf("Expected no mock to be added, got %v", len(server.mocks))
+				}
+			})
+		}
+	})
+}
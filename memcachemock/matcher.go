@@ -0,0 +1,134 @@
+package memcachemock
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+// Matcher is a value usable in Mock.Args to match an argument by a rule
+// instead of by equality.
+type Matcher interface {
+	// Match reports whether the argument matches.
+	Match(arg interface{}) bool
+	// String describes the matcher in expectation failures.
+	String() string
+}
+
+type matcher struct {
+	match       func(arg interface{}) bool
+	description string
+}
+
+func (m matcher) Match(arg interface{}) bool {
+	return m.match(arg)
+}
+
+func (m matcher) String() string {
+	return m.description
+}
+
+// Any matches any argument.
+func Any() Matcher {
+	return matcher{
+		match:       func(arg interface{}) bool { return true },
+		description: "Any()",
+	}
+}
+
+// Prefix matches a string argument starting with prefix.
+func Prefix(prefix string) Matcher {
+	return matcher{
+		match: func(arg interface{}) bool {
+			s, ok := arg.(string)
+			return ok && strings.HasPrefix(s, prefix)
+		},
+		description: fmt.Sprintf("Prefix(%q)", prefix),
+	}
+}
+
+// Regexp matches a string argument matching the regular expression expr.
+// It panics if expr can't be compiled.
+func Regexp(expr string) Matcher {
+	re := regexp.MustCompile(expr)
+	return matcher{
+		match: func(arg interface{}) bool {
+			s, ok := arg.(string)
+			return ok && re.MatchString(s)
+		},
+		description: fmt.Sprintf("Regexp(%q)", expr),
+	}
+}
+
+// Func matches an argument for which predicate returns true.
+func Func(predicate func(arg interface{}) bool) Matcher {
+	return matcher{
+		match:       predicate,
+		description: "Func()",
+	}
+}
+
+// ItemWithKey matches an item argument whose key matches key, either a
+// string or a Matcher, whatever its other fields.
+func ItemWithKey(key interface{}) Matcher {
+	return matcher{
+		match: func(arg interface{}) bool {
+			it, ok := arg.(*item.Item)
+			return ok && it != nil && matchArg(key, it.Key)
+		},
+		description: fmt.Sprintf("ItemWithKey(%v)", key),
+	}
+}
+
+// ExactItem matches an item argument equal to it, including its
+// Expiration and Flags, which are ignored when matching items otherwise.
+func ExactItem(it *item.Item) Matcher {
+	return matcher{
+		match: func(arg interface{}) bool {
+			other, ok := arg.(*item.Item)
+			if !ok || other == nil || it == nil {
+				return ok && other == it
+			}
+			return other.Key == it.Key &&
+				bytes.Equal(other.Value, it.Value) &&
+				other.Flags == it.Flags &&
+				other.Expiration == it.Expiration &&
+				other.CasID == it.CasID
+		},
+		description: fmt.Sprintf("ExactItem(%v)", formatArg(it)),
+	}
+}
+
+// hasMatcher reports whether any of the arguments is a Matcher.
+func hasMatcher(args Args) bool {
+	for _, v := range args {
+		if _, ok := v.(Matcher); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchArgs reports whether the arguments of a call match the arguments
+// of a mock.
+func matchArgs(expected, args Args) bool {
+	if len(expected) != len(args) {
+		return false
+	}
+	for i := range expected {
+		if !matchArg(expected[i], args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchArg(expected, arg interface{}) bool {
+	if m, ok := expected.(Matcher); ok {
+		return m.Match(arg)
+	}
+	return encodeArg(expected) == encodeArg(arg)
+}
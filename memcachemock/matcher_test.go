@@ -0,0 +1,110 @@
+package memcachemock
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/getmiranda/gomemcached/item"
+)
+
+func TestMatchers(t *testing.T) {
+
+	t.Run("Any", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectTouch("key", Any())
+
+		if err := server.GetMockedClient().Touch("key", 42); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(ItemWithKey(Prefix("user:"))).WillReturnError(errors.New("mirandas"))
+
+		client := server.GetMockedClient()
+		if err := client.Set(&item.Item{Key: "user:1", Value: []byte("1")}); err == nil || err.Error() != "mirandas" {
+			t.Errorf("Expected error to be %v, got %v", "mirandas", err)
+		}
+		if err := client.Set(&item.Item{Key: "post:1"}); !errors.Is(err, ErrMockNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrMockNotFound, err)
+		}
+	})
+
+	t.Run("Regexp", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectDelete(Regexp(`^session:\d+$`))
+
+		client := server.GetMockedClient()
+		if err := client.Delete("session:42"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := client.Delete("session:abc"); !errors.Is(err, ErrMockNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrMockNotFound, err)
+		}
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectGetMulti(Func(func(arg interface{}) bool {
+			keys, ok := arg.([]string)
+			return ok && len(keys) == 2
+		})).WillReturn(map[string]*item.Item{})
+
+		if _, err := server.GetMockedClient().GetMulti([]string{"a", "b"}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("ExactPrecedence", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectGet(Any()).WillReturnError(errors.New("any"))
+		server.ExpectGet("key").WillReturn(&item.Item{Key: "key"})
+
+		client := server.GetMockedClient()
+		if _, err := client.Get("key"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if _, err := client.Get("other"); err == nil || err.Error() != "any" {
+			t.Errorf("Expected error to be %v, got %v", "any", err)
+		}
+	})
+
+	t.Run("ExactItem", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(ExactItem(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10}))
+
+		client := server.GetMockedClient()
+		if err := client.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 10}); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := client.Set(&item.Item{Key: "key", Value: []byte("value"), Expiration: 20}); !errors.Is(err, ErrMockNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrMockNotFound, err)
+		}
+	})
+
+	t.Run("ArgumentsNotModified", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectSet(&item.Item{Key: "key", Value: []byte("value")})
+
+		it := &item.Item{Key: "key", Value: []byte("value"), Expiration: 10, Flags: 3}
+		if err := server.GetMockedClient().Set(it); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if it.Expiration != 10 || it.Flags != 3 {
+			t.Errorf("Expected item not to be modified, got %+v", it)
+		}
+	})
+
+	t.Run("UnmetMatcher", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectGet(Prefix("user:"))
+
+		rt := &recordingT{TB: t}
+		server.AssertExpectations(rt)
+		if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], `Get(Prefix("user:")) was never called`) {
+			t.Errorf("Expected matcher to be described, got %v", rt.errors)
+		}
+	})
+}
@@ -15,6 +15,12 @@ const (
 	OperationTouch          Operation = "Touch"
 	OperationDeleteAll      Operation = "DeleteAll"
 	OperationPing           Operation = "Ping"
+	OperationGetOrLoad      Operation = "GetOrLoad"
+	OperationMetaGet        Operation = "MetaGet"
+	OperationMetaSet        Operation = "MetaSet"
+	OperationMetaDelete     Operation = "MetaDelete"
+	OperationMetaArithmetic Operation = "MetaArithmetic"
+	OperationMetaNoop       Operation = "MetaNoop"
 )
 
 type Args []interface{}
@@ -23,10 +29,94 @@ type Return interface{}
 
 type Operation string
 
+// Response is a value and error returned by a mock.
+type Response struct {
+	Return Return
+	Error  error
+}
+
+// Responder computes the response of a mock from the arguments of the
+// call.
+type Responder func(args Args) (Return, error)
+
 type Mock struct {
 	Operation Operation
 	Args      Args
 
 	Return Return
 	Error  error
+
+	// Responses, if set, are returned in order instead of Return and
+	// Error, one per call. The last one is repeated once all were returned.
+	Responses []Response
+	// Responder, if set, is called to compute the response instead.
+	Responder Responder
+
+	times int
+}
+
+// respond returns the response to the call number n, starting at 0.
+func (m *Mock) respond(n int, args Args) (Return, error) {
+	if m.Responder != nil {
+		return m.Responder(args)
+	}
+	if len(m.Responses) > 0 {
+		if n >= len(m.Responses) {
+			n = len(m.Responses) - 1
+		}
+		return m.Responses[n].Return, m.Responses[n].Error
+	}
+	return m.Return, m.Error
+}
+
+// WillReturn sets the value returned by the mock.
+func (m *Mock) WillReturn(ret Return) *Mock {
+	m.Return = ret
+	return m
+}
+
+// WillReturnError sets the error returned by the mock.
+func (m *Mock) WillReturnError(err error) *Mock {
+	m.Error = err
+	return m
+}
+
+// ThenReturn adds a value returned by the mock once the previous
+// responses were returned.
+func (m *Mock) ThenReturn(ret Return) *Mock {
+	return m.then(Response{Return: ret})
+}
+
+// ThenReturnError adds an error returned by the mock once the previous
+// responses were returned.
+func (m *Mock) ThenReturnError(err error) *Mock {
+	return m.then(Response{Error: err})
+}
+
+func (m *Mock) then(resp Response) *Mock {
+	if len(m.Responses) == 0 {
+		m.Responses = append(m.Responses, Response{Return: m.Return, Error: m.Error})
+	}
+	m.Responses = append(m.Responses, resp)
+	return m
+}
+
+// WillRespond sets a function computing the response of the mock from
+// the arguments of each call.
+func (m *Mock) WillRespond(fn Responder) *Mock {
+	m.Responder = fn
+	return m
+}
+
+// Once expects the mock to be called once, it's like Times(1).
+func (m *Mock) Once() *Mock {
+	return m.Times(1)
+}
+
+// Times sets how many times the mock is expected to be called. Once it
+// was called n times it stops answering, and AssertExpectations fails if
+// it was called fewer times. Zero means any number of times.
+func (m *Mock) Times(n int) *Mock {
+	m.times = n
+	return m
 }
@@ -0,0 +1,90 @@
+package memcachemock
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+)
+
+func TestMockResponses(t *testing.T) {
+
+	t.Run("Sequence", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectGet("key").
+			WillReturnError(memcache.ErrServerError).
+			ThenReturnError(memcache.ErrCacheMiss).
+			ThenReturn(&item.Item{Key: "key", Value: []byte("value")})
+
+		client := server.GetMockedClient()
+		expected := []error{memcache.ErrServerError, memcache.ErrCacheMiss, nil, nil}
+		for i, want := range expected {
+			if _, err := client.Get("key"); !errors.Is(err, want) {
+				t.Errorf("Call %d: Expected error to be %v, got %v", i, want, err)
+			}
+		}
+	})
+
+	t.Run("ResponsesField", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.AddMock(&Mock{
+			Operation: OperationIncrement,
+			Args:      Args{"key", uint64(1)},
+			Responses: []Response{{Return: uint64(1)}, {Return: uint64(2)}},
+		})
+
+		client := server.GetMockedClient()
+		for _, want := range []uint64{1, 2, 2} {
+			if value, err := client.Increment("key", 1); err != nil || value != want {
+				t.Errorf("Expected value to be %v, got %v (%v)", want, value, err)
+			}
+		}
+	})
+
+	t.Run("Once", func(t *testing.T) {
+		server := NewMockServer(t)
+		server.ExpectPing().Once()
+
+		client := server.GetMockedClient()
+		if err := client.Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := client.Ping(); !errors.Is(err, ErrMockNotFound) {
+			t.Errorf("Expected error to be %v, got %v", ErrMockNotFound, err)
+		}
+	})
+
+	t.Run("Responder", func(t *testing.T) {
+		server := NewMockServer(t)
+		client := server.GetMockedClient()
+		stored := map[string]*item.Item{}
+		server.ExpectSet(Any()).WillRespond(func(args Args) (Return, error) {
+			it := args[0].(*item.Item)
+			stored[it.Key] = it
+			return nil, nil
+		})
+		server.ExpectGet(Any()).WillRespond(func(args Args) (Return, error) {
+			if it, ok := stored[args[0].(string)]; ok {
+				return it, nil
+			}
+			return nil, memcache.ErrCacheMiss
+		})
+		// Responders can use the client.
+		server.ExpectExists(Any()).WillRespond(func(args Args) (Return, error) {
+			_, err := client.Get(args[0].(string))
+			return err == nil, nil
+		})
+
+		if _, err := client.Get("key"); !errors.Is(err, memcache.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", memcache.ErrCacheMiss, err)
+		}
+		client.Set(&item.Item{Key: "key", Value: []byte("value")})
+		if it, err := client.Get("key"); err != nil || string(it.Value) != "value" {
+			t.Errorf("Expected value to be %v, got %v (%v)", "value", it, err)
+		}
+		if exists, err := client.Exists("key"); err != nil || !exists {
+			t.Errorf("Expected key to exist, got %v (%v)", exists, err)
+		}
+	})
+}
@@ -2,44 +2,76 @@ package memcachemock
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"strings"
 	"sync"
+	"testing"
+	"time"
 
 	"github.com/getmiranda/gomemcached/item"
 )
 
 var (
-	MockupServer = mockServer{
-		mocks:          make(map[string]*Mock),
-		memcacheClient: &clientMock{},
-	}
+	// MockupServer is the global mockup server returned by
+	// memcache.ClientBuilder.Build while it is started. Prefer
+	// NewMockServer for tests that can run in parallel.
+	MockupServer = newMockServer()
 
 	ErrMockNotFound        = errors.New("mock not found")
 	ErrInterfaceConvertion = errors.New("interface convertion error")
 )
 
-type mockServer struct {
+// MockServer holds the mocks answering the operations of its client.
+type MockServer struct {
+	Faults
+
 	enabled        bool
 	serverMutex    sync.Mutex
 	mocks          map[string]*Mock
-	memcacheClient *clientMock
+	matchers       []*Mock
+	memcacheClient *MockClient
+	calls          []Call
+	used           map[*Mock]int
+	timeout        time.Duration
+}
+
+// NewMockServer creates a mock server isolated from the global
+// MockupServer and from any other mock server, so tests using it can run
+// in parallel. Inject its client with memcache.ClientBuilder.WithClient
+// or use it directly. Its mocks are deleted when the test completes.
+func NewMockServer(t testing.TB) *MockServer {
+	m := newMockServer()
+	t.Cleanup(m.DeleteMocks)
+	return m
+}
+
+func newMockServer() *MockServer {
+	m := &MockServer{
+		mocks: make(map[string]*Mock),
+		used:  make(map[*Mock]int),
+	}
+	m.memcacheClient = &MockClient{server: m}
+	return m
 }
 
 // Start sets the enviroment to send all client requests
-// to the mockup server.
-func (m *mockServer) Start() {
+// to the mockup server. Only the global MockupServer is used by
+// memcache.ClientBuilder.Build, so Start doesn't affect the servers
+// created by NewMockServer.
+func (m *MockServer) Start() {
 	m.serverMutex.Lock()
 	defer m.serverMutex.Unlock()
 
 	m.enabled = true
 }
 
-// Stop stop sending requests to the mockup server
-func (m *mockServer) Stop() {
+// Stop stop sending requests to the mockup server. Like Start, it only
+// affects the global MockupServer.
+func (m *MockServer) Stop() {
 	m.serverMutex.Lock()
 	defer m.serverMutex.Unlock()
 
@@ -47,7 +79,7 @@ func (m *mockServer) Stop() {
 }
 
 // AddMockups add a mock to the mockup server.
-func (m *mockServer) AddMock(mock *Mock) {
+func (m *MockServer) AddMock(mock *Mock) {
 	m.serverMutex.Lock()
 	defer m.serverMutex.Unlock()
 
@@ -55,28 +87,166 @@ func (m *mockServer) AddMock(mock *Mock) {
 		return
 	}
 
+	if hasMatcher(mock.Args) {
+		m.matchers = append(m.matchers, mock)
+		return
+	}
 	m.mocks[m.getMockKey(mock.Operation, mock.Args)] = mock
 }
 
 // IsEnabled check whether the mock environment is enabled or not.
-func (m *mockServer) IsEnabled() bool {
+func (m *MockServer) IsEnabled() bool {
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
 	return m.enabled
 }
 
-// GetMockedClient gets the memcached mock client.
-func (m *mockServer) GetMockedClient() *clientMock {
+// GetMockedClient gets the memcached mock client, which answers with the
+// mocks of the server whether it is started or not.
+func (m *MockServer) GetMockedClient() *MockClient {
 	return m.memcacheClient
 }
 
 // DeleteMocks delete all mocks in every new test case to ensure a clean environment.
-func (m *mockServer) DeleteMocks() {
+func (m *MockServer) DeleteMocks() {
 	m.serverMutex.Lock()
 	defer m.serverMutex.Unlock()
 
 	m.mocks = make(map[string]*Mock)
+	m.matchers = nil
+	m.calls = nil
+	m.used = make(map[*Mock]int)
+	m.ClearFaults()
 }
 
-func (m *mockServer) cleanValue(value []byte) string {
+// SetTimeout sets the timeout of the clients without their own, given
+// with MockClient.WithTimeout. Their calls delayed longer than it by
+// injected faults fail with ErrTimeout once it elapses. Without a
+// timeout, the delays elapse in full.
+func (m *MockServer) SetTimeout(timeout time.Duration) {
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
+	m.timeout = timeout
+}
+
+func (m *MockServer) getTimeout() time.Duration {
+	m.serverMutex.Lock()
+	defer m.serverMutex.Unlock()
+
+	return m.timeout
+}
+
+// call records a call to the operation and returns the response of the
+// mock answering it, or nil if there is none or it was already called as
+// many times as it was expected to. Mocks with exact arguments take
+// precedence over mocks with matchers, which are tried in the order they
+// were added. Injected faults are applied first, with the timeout of the
+// client, or else of the server.
+func (m *MockServer) call(ctx context.Context, timeout time.Duration, op Operation, args ...Args) *Mock {
+	if err := ctx.Err(); err != nil {
+		return &Mock{Operation: op, Error: err}
+	}
+
+	call := Call{Operation: op}
+	if len(args) > 0 {
+		// Copy items so later changes by the caller don't alter the history.
+		for _, v := range args[0] {
+			if it, ok := v.(*item.Item); ok && it != nil {
+				cp := *it
+				v = &cp
+			}
+			call.Args = append(call.Args, v)
+		}
+	}
+
+	var keys []string
+	if op == OperationGetMulti && len(call.Args) > 0 {
+		keys, _ = call.Args[0].([]string)
+	}
+	inj := m.Draw(op, keys)
+	if inj.Delay > 0 {
+		if timeout == 0 {
+			timeout = m.getTimeout()
+		}
+		if err := m.wait(ctx, inj.Delay, timeout); err != nil {
+			inj.Err, inj.Dropped = err, nil
+		}
+	}
+	if inj.Err != nil && inj.Dropped == nil {
+		call.Fault = inj.Err
+		m.serverMutex.Lock()
+		m.calls = append(m.calls, call)
+		m.serverMutex.Unlock()
+		return &Mock{Operation: op, Error: inj.Err}
+	}
+
+	key := m.getMockKey(op, args...)
+
+	m.serverMutex.Lock()
+	mock := m.mocks[key]
+	if !m.available(mock) {
+		mock = nil
+		for _, candidate := range m.matchers {
+			if candidate.Operation == op && matchArgs(candidate.Args, call.Args) && m.available(candidate) {
+				mock = candidate
+				break
+			}
+		}
+	}
+	call.Mock = mock
+	m.calls = append(m.calls, call)
+	if mock == nil {
+		m.serverMutex.Unlock()
+		return nil
+	}
+	n := m.used[mock]
+	m.used[mock]++
+	// Responders may use the client, answer without holding the lock.
+	m.serverMutex.Unlock()
+
+	resp := &Mock{Operation: mock.Operation, Args: mock.Args}
+	resp.Return, resp.Error = mock.respond(n, call.Args)
+	if items, ok := resp.Return.(map[string]*item.Item); ok && inj.Dropped != nil && resp.Error == nil {
+		partial := make(map[string]*item.Item, len(items))
+		for k, it := range items {
+			if !inj.Dropped[k] {
+				partial[k] = it
+			}
+		}
+		resp.Return, resp.Error = partial, inj.Err
+	}
+	return resp
+}
+
+// wait waits for the delay of a call, or for the timeout of the client if
+// it's shorter, in which case it returns ErrTimeout.
+func (m *MockServer) wait(ctx context.Context, delay, timeout time.Duration) error {
+	d := delay
+	if timeout > 0 && d > timeout {
+		d = timeout
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if d < delay {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// available reports whether the mock can still answer calls.
+func (m *MockServer) available(mock *Mock) bool {
+	return mock != nil && (mock.times == 0 || m.used[mock] < mock.times)
+}
+
+func cleanValue(value []byte) string {
 	valueString := string(value)
 	valueString = strings.TrimSpace(valueString)
 	if valueString == "" {
@@ -87,19 +257,11 @@ func (m *mockServer) cleanValue(value []byte) string {
 	return valueString
 }
 
-func (m *mockServer) getMockKey(op Operation, args ...Args) string {
+func (m *MockServer) getMockKey(op Operation, args ...Args) string {
 	key := string(op)
 	if len(args) > 0 {
 		for _, v := range args[0] {
-			var buffer bytes.Buffer
-			enc := gob.NewEncoder(&buffer)
-			it, ok := v.(*item.Item)
-			if ok {
-				it.Expiration = 0
-				it.Flags = 0
-			}
-			enc.Encode(v)
-			key += m.cleanValue(buffer.Bytes())
+			key += encodeArg(v)
 		}
 	}
 	hasher := md5.New()
@@ -107,3 +269,18 @@ func (m *mockServer) getMockKey(op Operation, args ...Args) string {
 	newKey := hex.EncodeToString(hasher.Sum(nil))
 	return newKey
 }
+
+// encodeArg encodes an argument for comparison. The Expiration and Flags
+// of items are ignored, use ExactItem to match them.
+func encodeArg(v interface{}) string {
+	if it, ok := v.(*item.Item); ok && it != nil {
+		cp := *it
+		cp.Expiration = 0
+		cp.Flags = 0
+		v = &cp
+	}
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+	enc.Encode(v)
+	return cleanValue(buffer.Bytes())
+}
@@ -0,0 +1,562 @@
+// Package memcachetest provides an in-process memcached server speaking
+// the text protocol, for integration tests that can't run a real one.
+package memcachetest
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+const (
+	// Version is the version reported by the server.
+	Version = "1.6.21"
+
+	// DefaultMaxItemSize is the largest value the server stores by default,
+	// matching memcached's default item size limit.
+	DefaultMaxItemSize = 1024 * 1024
+)
+
+// Server is an in-process memcached server listening on 127.0.0.1. It
+// supports the get, gets, set, add, replace, cas, append, prepend, incr,
+// decr, touch, delete, flush_all, version, stats and quit commands.
+//
+// Faults injected into the server apply to the commands of the client
+// operations: get and gets with several keys are GetMulti, version is
+// Ping. Injected errors are answered with a server error, resets close
+// the connection abruptly, and the keys dropped from GetMulti calls are
+// missing from the response.
+type Server struct {
+	memcachemock.Faults
+
+	listener    net.Listener
+	certificate *x509.Certificate
+	done        chan struct{}
+	store       *memcachemock.FakeClient
+	started     time.Time
+
+	mu          sync.Mutex
+	conns       map[net.Conn]struct{}
+	offset      time.Duration
+	maxItemSize int
+	closed      bool
+	wg          sync.WaitGroup
+
+	totalConns uint64
+	cmdGet     uint64
+	cmdSet     uint64
+	getHits    uint64
+	getMisses  uint64
+}
+
+// NewServer starts a server on a random port of 127.0.0.1. It is shut
+// down when the test and all its subtests complete.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s, err := Start()
+	if err != nil {
+		t.Fatalf("memcachetest: starting server: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+// Start starts a server on a random port of 127.0.0.1. The caller is
+// responsible for closing it.
+func Start() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return start(l), nil
+}
+
+// start starts a server accepting the connections of l.
+func start(l net.Listener) *Server {
+	s := &Server{
+		listener:    l,
+		done:        make(chan struct{}),
+		store:       memcachemock.NewFakeClient(),
+		started:     time.Now(),
+		conns:       make(map[net.Conn]struct{}),
+		maxItemSize: DefaultMaxItemSize,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr returns the address the server listens on, suitable for
+// ClientBuilder.WithServers.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes all its connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	err := s.listener.Close()
+	for nc := range s.conns {
+		nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Advance moves the server clock forward, expiring the items whose
+// expiration time is reached without waiting for it.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset += d
+}
+
+// SetMaxItemSize changes the largest value the server accepts. Larger
+// values are rejected with a server error, like memcached does.
+func (s *Server) SetMaxItemSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxItemSize = n
+}
+
+// Len returns the number of items stored in the server.
+func (s *Server) Len() int {
+	s.syncClock()
+	return s.store.Len()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		atomic.AddUint64(&s.totalConns, 1)
+
+		go func() {
+			defer s.wg.Done()
+			if reset := s.handleConn(nc); reset {
+				conn := nc
+				if tc, ok := conn.(*tls.Conn); ok {
+					conn = tc.NetConn()
+				}
+				if tc, ok := conn.(*net.TCPConn); ok {
+					tc.SetLinger(0)
+				}
+			}
+
+			s.mu.Lock()
+			delete(s.conns, nc)
+			s.mu.Unlock()
+			nc.Close()
+		}()
+	}
+}
+
+// handleConn serves the commands of a connection until it must be
+// closed. It reports whether the connection must be reset.
+func (s *Server) handleConn(nc net.Conn) bool {
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			rw.WriteString("ERROR\r\n")
+		} else {
+			inj := s.Draw(operation(fields), fields[1:])
+			if inj.Delay > 0 && !s.sleep(inj.Delay) {
+				return false
+			}
+			if inj.Reset {
+				return true
+			}
+			s.syncClock()
+			if quit := s.handleCommand(rw, fields, inj); quit {
+				rw.Flush()
+				return false
+			}
+		}
+		if err := rw.Flush(); err != nil {
+			return false
+		}
+	}
+}
+
+// sleep waits for d, it reports false if the server was closed meanwhile.
+func (s *Server) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// operation returns the client operation sending a command.
+func operation(fields []string) memcachemock.Operation {
+	switch fields[0] {
+	case "get", "gets":
+		if len(fields) > 2 {
+			return memcachemock.OperationGetMulti
+		}
+		return memcachemock.OperationGet
+	case "set":
+		return memcachemock.OperationSet
+	case "add":
+		return memcachemock.OperationAdd
+	case "replace":
+		return memcachemock.OperationReplace
+	case "cas":
+		return memcachemock.OperationCompareAndSwap
+	case "incr":
+		return memcachemock.OperationIncrement
+	case "decr":
+		return memcachemock.OperationDecrement
+	case "touch":
+		return memcachemock.OperationTouch
+	case "delete":
+		return memcachemock.OperationDelete
+	case "flush_all":
+		return memcachemock.OperationFlushAll
+	case "version":
+		return memcachemock.OperationPing
+	}
+	return ""
+}
+
+// handleCommand runs a command and writes its response. It reports
+// whether the connection must be closed.
+func (s *Server) handleCommand(rw *bufio.ReadWriter, fields []string, inj memcachemock.Injection) bool {
+	cmd, args := fields[0], fields[1:]
+	switch cmd {
+	case "get", "gets":
+		if inj.Err != nil && inj.Dropped == nil {
+			serverError(rw, false, inj.Err)
+			return false
+		}
+		s.handleGet(rw, args, cmd == "gets", inj.Dropped)
+		return false
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return s.handleStore(rw, cmd, args, inj.Err)
+	}
+
+	if inj.Err != nil {
+		serverError(rw, noreply(args), inj.Err)
+		return false
+	}
+	switch cmd {
+	case "incr", "decr":
+		s.handleIncrDecr(rw, cmd, args)
+	case "touch":
+		s.handleTouch(rw, args)
+	case "delete":
+		s.handleDelete(rw, args)
+	case "flush_all":
+		s.store.FlushAll()
+		reply(rw, noreply(args), "OK")
+	case "version":
+		fmt.Fprintf(rw, "VERSION %s\r\n", Version)
+	case "stats":
+		s.handleStats(rw)
+	case "quit":
+		return true
+	default:
+		rw.WriteString("ERROR\r\n")
+	}
+	return false
+}
+
+func (s *Server) handleGet(rw *bufio.ReadWriter, keys []string, withCas bool, dropped map[string]bool) {
+	if len(keys) == 0 {
+		rw.WriteString("ERROR\r\n")
+		return
+	}
+	items, err := s.store.GetMulti(keys)
+	if err != nil {
+		clientError(rw, "bad command line format")
+		return
+	}
+
+	atomic.AddUint64(&s.cmdGet, uint64(len(keys)))
+	for _, key := range keys {
+		it, ok := items[key]
+		if dropped[key] {
+			continue
+		}
+		if !ok {
+			atomic.AddUint64(&s.getMisses, 1)
+			continue
+		}
+		atomic.AddUint64(&s.getHits, 1)
+		if withCas {
+			fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n", it.Key, it.Flags, len(it.Value), it.CasID)
+		} else {
+			fmt.Fprintf(rw, "VALUE %s %d %d\r\n", it.Key, it.Flags, len(it.Value))
+		}
+		rw.Write(it.Value)
+		rw.WriteString("\r\n")
+	}
+	rw.WriteString("END\r\n")
+}
+
+// handleStore handles the storage commands:
+//
+//	<cmd> <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *Server) handleStore(rw *bufio.ReadWriter, cmd string, args []string, fault error) bool {
+	nargs := 4
+	if cmd == "cas" {
+		nargs = 5
+	}
+	if len(args) < nargs {
+		rw.WriteString("ERROR\r\n")
+		return false
+	}
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 32)
+	size, err3 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		clientError(rw, "bad command line format")
+		return true
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return true
+	}
+	if string(data[size:]) != "\r\n" {
+		clientError(rw, "bad data chunk")
+		return false
+	}
+
+	if fault != nil {
+		serverError(rw, noreply(args[nargs:]), fault)
+		return false
+	}
+
+	s.mu.Lock()
+	maxItemSize := s.maxItemSize
+	s.mu.Unlock()
+	if size > maxItemSize {
+		rw.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return false
+	}
+
+	it := &item.Item{
+		Key:        args[0],
+		Value:      data[:size],
+		Flags:      uint32(flags),
+		Expiration: int32(exptime),
+	}
+	atomic.AddUint64(&s.cmdSet, 1)
+
+	var err error
+	switch cmd {
+	case "set":
+		err = s.store.Set(it)
+	case "add":
+		err = s.store.Add(it)
+	case "replace":
+		err = s.store.Replace(it)
+	case "append":
+		_, err = s.store.MetaSet(context.Background(), it, &item.MetaSetOptions{Mode: item.MetaSetModeAppend})
+	case "prepend":
+		_, err = s.store.MetaSet(context.Background(), it, &item.MetaSetOptions{Mode: item.MetaSetModePrepend})
+	case "cas":
+		it.CasID, err = strconv.ParseUint(args[4], 10, 64)
+		if err != nil {
+			clientError(rw, "bad command line format")
+			return false
+		}
+		err = s.store.CompareAndSwap(it)
+	}
+
+	quiet := noreply(args[nargs:])
+	switch {
+	case err == nil:
+		reply(rw, quiet, "STORED")
+	case errors.Is(err, memcache.ErrNotStored):
+		reply(rw, quiet, "NOT_STORED")
+	case errors.Is(err, memcache.ErrCASConflict):
+		reply(rw, quiet, "EXISTS")
+	case errors.Is(err, memcache.ErrCacheMiss):
+		reply(rw, quiet, "NOT_FOUND")
+	default:
+		clientError(rw, "bad command line format")
+	}
+	return false
+}
+
+func (s *Server) handleIncrDecr(rw *bufio.ReadWriter, cmd string, args []string) {
+	if len(args) < 2 {
+		rw.WriteString("ERROR\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		clientError(rw, "invalid numeric delta argument")
+		return
+	}
+
+	var value uint64
+	if cmd == "incr" {
+		value, err = s.store.Increment(args[0], delta)
+	} else {
+		value, err = s.store.Decrement(args[0], delta)
+	}
+
+	quiet := noreply(args[2:])
+	switch {
+	case err == nil:
+		reply(rw, quiet, strconv.FormatUint(value, 10))
+	case errors.Is(err, memcache.ErrCacheMiss):
+		reply(rw, quiet, "NOT_FOUND")
+	case errors.Is(err, memcachemock.ErrNonNumericValue):
+		clientError(rw, "cannot increment or decrement non-numeric value")
+	default:
+		clientError(rw, "bad command line format")
+	}
+}
+
+func (s *Server) handleTouch(rw *bufio.ReadWriter, args []string) {
+	if len(args) < 2 {
+		rw.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		clientError(rw, "invalid exptime argument")
+		return
+	}
+
+	err = s.store.Touch(args[0], int32(exptime))
+	quiet := noreply(args[2:])
+	switch {
+	case err == nil:
+		reply(rw, quiet, "TOUCHED")
+	case errors.Is(err, memcache.ErrCacheMiss):
+		reply(rw, quiet, "NOT_FOUND")
+	default:
+		clientError(rw, "bad command line format")
+	}
+}
+
+func (s *Server) handleDelete(rw *bufio.ReadWriter, args []string) {
+	if len(args) < 1 {
+		rw.WriteString("ERROR\r\n")
+		return
+	}
+
+	err := s.store.Delete(args[0])
+	quiet := noreply(args[1:])
+	switch {
+	case err == nil:
+		reply(rw, quiet, "DELETED")
+	case errors.Is(err, memcache.ErrCacheMiss):
+		reply(rw, quiet, "NOT_FOUND")
+	default:
+		clientError(rw, "bad command line format")
+	}
+}
+
+func (s *Server) handleStats(rw *bufio.ReadWriter) {
+	s.mu.Lock()
+	currConns := len(s.conns)
+	s.mu.Unlock()
+
+	stats := []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(time.Since(s.started) / time.Second)},
+		{"time", time.Now().Unix()},
+		{"version", Version},
+		{"curr_connections", currConns},
+		{"total_connections", atomic.LoadUint64(&s.totalConns)},
+		{"cmd_get", atomic.LoadUint64(&s.cmdGet)},
+		{"cmd_set", atomic.LoadUint64(&s.cmdSet)},
+		{"get_hits", atomic.LoadUint64(&s.getHits)},
+		{"get_misses", atomic.LoadUint64(&s.getMisses)},
+		{"curr_items", s.store.Len()},
+	}
+	for _, stat := range stats {
+		fmt.Fprintf(rw, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	rw.WriteString("END\r\n")
+}
+
+// syncClock moves the store clock to the current time plus the offset
+// set with Advance.
+func (s *Server) syncClock() {
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+
+	s.store.SetNow(time.Now().Add(offset))
+}
+
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func reply(rw *bufio.ReadWriter, quiet bool, msg string) {
+	if quiet {
+		return
+	}
+	rw.WriteString(msg + "\r\n")
+}
+
+func clientError(rw *bufio.ReadWriter, msg string) {
+	rw.WriteString("CLIENT_ERROR " + msg + "\r\n")
+}
+
+func serverError(rw *bufio.ReadWriter, quiet bool, err error) {
+	reply(rw, quiet, "SERVER_ERROR "+err.Error())
+}
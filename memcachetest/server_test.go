@@ -0,0 +1,301 @@
+package memcachetest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mc "github.com/bradfitz/gomemcache/memcache"
+	"github.com/getmiranda/gomemcached/item"
+	"github.com/getmiranda/gomemcached/memcache"
+	"github.com/getmiranda/gomemcached/memcachemock"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(t)
+	client := memcache.NewBuilder().WithServers(s.Addr()).Build()
+
+	t.Run("SetGet", func(t *testing.T) {
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar"), Flags: 3}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "bar" || it.Flags != 3 {
+			t.Errorf("Expected item to be %v, got %v", "foo=bar", it)
+		}
+	})
+
+	t.Run("GetMiss", func(t *testing.T) {
+		if _, err := client.Get("missing"); !errors.Is(err, mc.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("AddReplace", func(t *testing.T) {
+		if err := client.Add(&item.Item{Key: "foo", Value: []byte("baz")}); !errors.Is(err, mc.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrNotStored, err)
+		}
+		if err := client.Replace(&item.Item{Key: "nope", Value: []byte("baz")}); !errors.Is(err, mc.ErrNotStored) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrNotStored, err)
+		}
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		client.Set(&item.Item{Key: "cas", Value: []byte("1")})
+		first, _ := client.Get("cas")
+		second, _ := client.Get("cas")
+
+		first.Value = []byte("2")
+		if err := client.CompareAndSwap(first); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		second.Value = []byte("3")
+		if err := client.CompareAndSwap(second); !errors.Is(err, mc.ErrCASConflict) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrCASConflict, err)
+		}
+	})
+
+	t.Run("IncrementDecrement", func(t *testing.T) {
+		client.Set(&item.Item{Key: "counter", Value: []byte("10")})
+		if value, err := client.Increment("counter", 5); err != nil || value != 15 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 15, value, err)
+		}
+		if value, err := client.Decrement("counter", 20); err != nil || value != 0 {
+			t.Errorf("Expected value to be %v, got %v (%v)", 0, value, err)
+		}
+	})
+
+	t.Run("GetMulti", func(t *testing.T) {
+		client.Set(&item.Item{Key: "a", Value: []byte("1")})
+		client.Set(&item.Item{Key: "b", Value: []byte("2")})
+		items, err := client.GetMulti([]string{"a", "b", "c"})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(items) != 2 {
+			t.Errorf("Expected %v items, got %v", 2, len(items))
+		}
+	})
+
+	t.Run("TouchExpiration", func(t *testing.T) {
+		client.Set(&item.Item{Key: "ttl", Value: []byte("1"), Expiration: 10})
+		if err := client.Touch("ttl", 100); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		s.Advance(time.Second * 50)
+		if _, err := client.Get("ttl"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		s.Advance(time.Second * 50)
+		if _, err := client.Get("ttl"); !errors.Is(err, mc.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		client.Set(&item.Item{Key: "del", Value: []byte("1")})
+		if err := client.Delete("del"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := client.Delete("del"); !errors.Is(err, mc.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		if err := client.Ping(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		s.SetMaxItemSize(4)
+		defer s.SetMaxItemSize(DefaultMaxItemSize)
+
+		if err := client.Set(&item.Item{Key: "big", Value: []byte("12345")}); err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+
+	t.Run("FlushAll", func(t *testing.T) {
+		if err := client.FlushAll(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if s.Len() != 0 {
+			t.Errorf("Expected %v items, got %v", 0, s.Len())
+		}
+	})
+}
+
+func TestServerCommands(t *testing.T) {
+	s := NewServer(t)
+	nc, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+
+	send := func(cmd string, lines int) string {
+		fmt.Fprint(nc, cmd)
+		var resp []string
+		for i := 0; i < lines; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			resp = append(resp, strings.TrimSpace(line))
+		}
+		return strings.Join(resp, "|")
+	}
+
+	tests := []struct {
+		cmd   string
+		lines int
+		want  string
+	}{
+		{"set k 0 0 1\r\na\r\n", 1, "STORED"},
+		{"append k 0 0 1\r\nb\r\n", 1, "STORED"},
+		{"prepend k 0 0 1\r\nc\r\n", 1, "STORED"},
+		{"get k\r\n", 3, "VALUE k 0 3|cab|END"},
+		{"append missing 0 0 1\r\nb\r\n", 1, "NOT_STORED"},
+		{"set n 0 0 1 noreply\r\n1\r\nincr n 1\r\n", 1, "2"},
+		{"incr k 1\r\n", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value"},
+		{"version\r\n", 1, "VERSION " + Version},
+		{"bogus\r\n", 1, "ERROR"},
+	}
+	for _, tt := range tests {
+		if got := send(tt.cmd, tt.lines); got != tt.want {
+			t.Errorf("%q: Expected %q, got %q", tt.cmd, tt.want, got)
+		}
+	}
+}
+
+func TestServerMultipleServers(t *testing.T) {
+	first, second := NewServer(t), NewServer(t)
+	client := memcache.NewBuilder().
+		WithServers(first.Addr(), second.Addr()).
+		UseKetama().
+		Build()
+
+	for i := 0; i < 100; i++ {
+		if err := client.Set(&item.Item{Key: fmt.Sprintf("key:%d", i), Value: []byte("v")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if first.Len() == 0 || second.Len() == 0 || first.Len()+second.Len() != 100 {
+		t.Errorf("Expected keys to be spread over both servers, got %v and %v", first.Len(), second.Len())
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+
+	t.Run("Timeout", func(t *testing.T) {
+		s := NewServer(t)
+		s.InjectFault(memcachemock.Fault{Delay: time.Millisecond * 200})
+		client := memcache.NewBuilder().WithServers(s.Addr()).SetTimeout(time.Millisecond * 20).Build()
+
+		var netErr net.Error
+		if _, err := client.Get("foo"); !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Expected a timeout error, got %v", err)
+		}
+	})
+
+	t.Run("ServerError", func(t *testing.T) {
+		s := NewServer(t)
+		s.InjectFault(memcachemock.Fault{Operations: []memcachemock.Operation{memcachemock.OperationSet}, ErrorRate: 1})
+		client := memcache.NewBuilder().WithServers(s.Addr()).Build()
+
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar")}); err == nil {
+			t.Errorf("Expected an error")
+		}
+		if err := client.Delete("foo"); !errors.Is(err, mc.ErrCacheMiss) {
+			t.Errorf("Expected error to be %v, got %v", mc.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		s := NewServer(t)
+		s.InjectFault(memcachemock.Fault{ResetRate: 1})
+		client := memcache.NewBuilder().WithServers(s.Addr()).Build()
+
+		if err := client.Ping(); err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+
+	t.Run("PartialGetMulti", func(t *testing.T) {
+		s := NewServer(t)
+		client := memcache.NewBuilder().WithServers(s.Addr()).Build()
+		var keys []string
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key:%d", i)
+			keys = append(keys, key)
+			client.Set(&item.Item{Key: key, Value: []byte("v")})
+		}
+		s.SetSeed(1)
+		s.InjectFault(memcachemock.Fault{PartialRate: 0.5})
+
+		items, err := client.GetMulti(keys)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(items) == 0 || len(items) == len(keys) {
+			t.Errorf("Expected some items to be returned, got %v", len(items))
+		}
+	})
+}
+
+func TestTLSServer(t *testing.T) {
+	s := NewTLSServer(t, nil)
+
+	t.Run("Certificate", func(t *testing.T) {
+		cert := s.Certificate()
+		if cert == nil {
+			t.Fatalf("Expected a certificate, got nil")
+		}
+		if err := cert.VerifyHostname("localhost"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("SetGet", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(s.Certificate())
+		client := memcache.NewBuilder().
+			WithServers(s.Addr()).
+			WithTLSConfig(&tls.Config{RootCAs: pool}).
+			Build()
+
+		if err := client.Set(&item.Item{Key: "foo", Value: []byte("bar")}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		it, err := client.Get("foo")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(it.Value) != "bar" {
+			t.Errorf("Expected value to be %v, got %v", "bar", string(it.Value))
+		}
+	})
+
+	t.Run("Plaintext", func(t *testing.T) {
+		client := memcache.NewBuilder().
+			WithServers(s.Addr()).
+			SetTimeout(time.Millisecond * 200).
+			Build()
+
+		if err := client.Ping(); err == nil {
+			t.Errorf("Expected an error, got nil")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package memcachetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// NewTLSServer starts a server on a random port of 127.0.0.1 serving over
+// TLS with config, like NewServer. If config has no certificate, the
+// server uses a self-signed certificate for localhost and 127.0.0.1,
+// returned by Certificate.
+func NewTLSServer(t testing.TB, config *tls.Config) *Server {
+	t.Helper()
+
+	s, err := StartTLS(config)
+	if err != nil {
+		t.Fatalf("memcachetest: starting server: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+// StartTLS starts a server on a random port of 127.0.0.1 serving over TLS
+// with config, like Start. If config has no certificate, the server uses
+// a self-signed certificate for localhost and 127.0.0.1, returned by
+// Certificate.
+func StartTLS(config *tls.Config) (*Server, error) {
+	if config == nil {
+		config = new(tls.Config)
+	} else {
+		config = config.Clone()
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		cert, err := NewCertificate("localhost", "127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := start(tls.NewListener(l, config))
+	if len(config.Certificates) > 0 {
+		s.certificate = config.Certificates[0].Leaf
+		if s.certificate == nil && len(config.Certificates[0].Certificate) > 0 {
+			s.certificate, _ = x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		}
+	}
+	return s, nil
+}
+
+// Certificate returns the certificate of a TLS server, nil for other
+// servers.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.certificate
+}
+
+// NewCertificate creates a self-signed certificate for the host names and
+// IP addresses, valid for servers and clients. Its Leaf is set, so it can
+// be added to the certificate pools trusting it.
+func NewCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"memcachetest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}